@@ -0,0 +1,58 @@
+/*
+Copyright © 2023 Felix Geisendörfer
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show the effective configuration and where it was loaded from",
+	Long: `Show the effective configuration and where it was loaded from.
+
+Each setting is taken from the first of the following layers that provides it:
+
+  <gitroot>/.gh-stack.yml  the repository config
+  ~/.gh-stack.yml          the user config
+  git remote <name>        host, owner and repo inferred from the remote url
+  default                  the built-in defaults
+
+The forge token is resolved from the environment, the gh cli, git credential
+helpers and the config files, in that order, and shown as unset if none is
+found. Secrets are redacted and unknown keys in the config files are reported
+as errors.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := configOnlyPreRunE(cmd, args); err != nil {
+			return err
+		}
+		ctx.LoadToken()
+		return nil
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		settings, err := ctx.Config().Settings()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, s := range settings {
+			source := s.Source
+			if source == "" {
+				source = "unset"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, s.Value, source)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2023 Felix Geisendörfer
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/felixge/gh-stack/internal/stack"
+	"github.com/spf13/cobra"
+)
+
+var installHooksForce bool
+
+// installHooksCmd represents the install-hooks command
+var installHooksCmd = &cobra.Command{
+	Use:   "install-hooks",
+	Short: "Install a commit-msg hook that assigns Commit-UIDs to new commits",
+	Long: `Install a commit-msg hook that adds a Commit-UID trailer to every new commit
+that doesn't have one yet.
+
+This is optional. Without the hook, sync assigns the missing UIDs by rewriting
+the commits of the stack, which changes their hashes.`,
+	PersistentPreRunE: configOnlyPreRunE,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		path, err := stack.InstallHooks(ctx, executable, installHooksForce)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "installed %s\n", path)
+		return nil
+	},
+}
+
+// commitMsgHookCmd is run by the hook installed by install-hooks.
+var commitMsgHookCmd = &cobra.Command{
+	Use:               "commit-msg-hook <file>",
+	Short:             "Add a Commit-UID trailer to the given commit message file",
+	Hidden:            true,
+	Args:              cobra.ExactArgs(1),
+	PersistentPreRunE: configOnlyPreRunE,
+	RunE: func(_ *cobra.Command, args []string) error {
+		return stack.AddUIDTrailer(ctx, args[0])
+	},
+}
+
+func init() {
+	installHooksCmd.Flags().BoolVar(&installHooksForce, "force", false, "Replace an existing commit-msg hook")
+	rootCmd.AddCommand(installHooksCmd)
+	rootCmd.AddCommand(commitMsgHookCmd)
+}
@@ -0,0 +1,31 @@
+/*
+Copyright © 2023 Felix Geisendörfer
+*/
+package cmd
+
+import (
+	"github.com/felixge/gh-stack/internal/stack"
+	"github.com/spf13/cobra"
+)
+
+// rebaseCmd represents the rebase command
+var rebaseCmd = &cobra.Command{
+	Use:   "rebase",
+	Short: "Start an interactive rebase of the stack onto the target branch",
+	Long: `Fetch the remotes and start git rebase --interactive of the commits of the
+stack onto the target branch, in the terminal like running it yourself.
+
+If the rebase stops for a conflict, resolve it and continue with
+git rebase --continue as usual. Run git stack sync afterwards to update the
+pull requests.
+
+Like sync, rebase refuses to run while another gh-stack process holds the lock
+of the repository, or while the last sync is interrupted.`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return stack.Rebase(cmd.Context(), ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rebaseCmd)
+}
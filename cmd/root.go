@@ -22,14 +22,17 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/felixge/gh-stack/internal/stack"
 	"github.com/spf13/cobra"
 )
 
 var (
-	ctxOpt = stack.ContextOptions{LoadConfig: true, LoadGithub: true}
+	ctxOpt = stack.ContextOptions{LoadConfig: true, LoadForge: true}
 	ctx    *stack.Context
 )
 
@@ -47,17 +50,41 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
-	PersistentPreRunE: func(_ *cobra.Command, _ []string) (err error) {
-		ctx, err = ctxOpt.NewContext()
+	PersistentPreRunE: func(cmd *cobra.Command, _ []string) (err error) {
+		ctx, err = ctxOpt.NewContext(cmd.Context())
 		return
 	},
 }
 
+// configOnlyPreRunE creates a context for commands that only need the config,
+// such as the commit-msg hook, so they keep working without a merge base or
+// forge token.
+func configOnlyPreRunE(cmd *cobra.Command, _ []string) (err error) {
+	opt := ctxOpt
+	opt.LoadForge = false
+	opt.SkipMergeBase = true
+	ctx, err = opt.NewContext(cmd.Context())
+	return
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+//
+// The first SIGINT or SIGTERM cancels the context of the command, which
+// interrupts the git commands and forge requests it runs and lets it finish
+// recording its progress and release its lock. A second signal exits right
+// away.
 func Execute() {
-	err := rootCmd.Execute()
-	if err != nil {
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCtx.Done()
+		stop()
+	}()
+	err := rootCmd.ExecuteContext(sigCtx)
+	if sigCtx.Err() != nil {
+		// the conventional exit code of processes killed by SIGINT
+		os.Exit(130)
+	} else if err != nil {
 		os.Exit(1)
 	}
 }
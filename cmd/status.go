@@ -4,42 +4,93 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/felixge/gh-stack/internal/stack"
 	"github.com/spf13/cobra"
 )
 
+var statusOffline bool
+
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
 	Use:   "status",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	RunE: func(_ *cobra.Command, _ []string) error {
-		// var statusStack stack.StatusStack
-		// if err := statusStack.Load(ctx); err != nil {
-		// 	return err
-		// }
-		// table := tablewriter.NewWriter(os.Stdout)
-		// table.SetBorder(false)
-		// table.SetTablePadding("  ")
-		// table.SetNoWhiteSpace(true)
-		// for _, commit := range statusStack.LocalStack.Commits {
-		// 	status := "new"
-		// 	remoteStack := statusStack.RemoteStacks.Stacks[commit.UID]
-		// 	if remoteStack != nil {
-		// 		status = "unchanged"
-		// 	}
-		// 	fmt.Fprintf(os.Stdout, "%s r3: r3 ⛈️😴⬇️ %s - <PR URL>\n", status, commit.Oneline())
-		// 	table.Append([]string{"", "new", "r1", commit.Oneline()})
-		// }
-		// // table.Render()
-		return nil
+	Short: "Show the status of the commits and pull requests of the stack",
+	Long: `Show the status of each commit of the stack compared to its
+gh-stack-commit-<Commit-UID> branch, along with its pull request and the CI
+and review status of it.
+
+With --offline, the remotes are not fetched and the forge is not contacted.
+The statuses are computed from the remote-tracking refs of the last fetch, and
+the pull requests are shown as of the last online status. Both are marked with
+their age, and pull requests that were never loaded are shown as unknown.`,
+	PersistentPreRunE: func(cmd *cobra.Command, _ []string) (err error) {
+		opt := ctxOpt
+		opt.Offline = statusOffline
+		ctx, err = opt.NewContext(cmd.Context())
+		return
 	},
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		var statusStack stack.StatusStack
+		if err := statusStack.Load(cmd.Context(), ctx); err != nil {
+			return err
+		} else if err := statusStack.LoadPullRequests(cmd.Context(), ctx); err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if op := statusStack.Interrupted; op != nil {
+			fmt.Fprintf(out, "warning: %s\n\n", (&stack.InterruptedError{Op: op}).Error())
+		}
+		if statusStack.Offline {
+			fmt.Fprintf(out, "offline: remotes fetched: %s, pull requests loaded: %s\n\n",
+				formatAge(statusStack.FetchedAt), formatAge(statusStack.PullRequestsFetchedAt))
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STATUS\tCOMMIT\tPULL REQUEST\tCI\tREVIEW")
+		for _, item := range statusStack.StatusItems {
+			pr, ci, review := "-", "-", "-"
+			switch {
+			case item.UID == "":
+				// not synced yet
+			case item.Err != nil && statusStack.Offline:
+				pr = "unknown"
+			case item.Err != nil:
+				pr = "error: " + item.Err.Error()
+			case item.PullRequest != nil:
+				pr = item.PullRequest.URL
+				ci, review = string(item.PullRequest.CI), string(item.PullRequest.Review)
+				if ci == "" {
+					ci = "none"
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Status(), item.Oneline, pr, ci, review)
+		}
+		return w.Flush()
+	},
+}
+
+// formatAge returns how long ago t was in a compact form, or "unknown" if t
+// is zero.
+func formatAge(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case t.IsZero():
+		return "unknown"
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
 }
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVar(&statusOffline, "offline", false, "Don't fetch or contact the forge, show the last known state")
 }
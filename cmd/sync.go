@@ -0,0 +1,48 @@
+/*
+Copyright © 2023 Felix Geisendörfer
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/felixge/gh-stack/internal/stack"
+	"github.com/spf13/cobra"
+)
+
+var syncContinue bool
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Create or update the pull requests of the stack",
+	Long: `Push each commit of the stack to its gh-stack-commit-<Commit-UID> branch and
+create or update a pull request for it.
+
+Set push_remote_name to the remote of your fork if you can't push branches to
+the target repository. Pull requests are then opened from the fork.
+
+The progress of sync is recorded step by step. If it is interrupted, e.g. by
+a network error while updating the pull requests, the next sync refuses to run
+until the interrupted one is finished with --continue or rolled back with
+git stack undo.`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		sync := stack.Sync
+		if syncContinue {
+			sync = stack.ResumeSync
+		}
+		prs, err := sync(cmd.Context(), ctx)
+		if err != nil {
+			return err
+		}
+		for _, pr := range prs {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", pr.URL, pr.Title)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().BoolVar(&syncContinue, "continue", false, "Finish the interrupted sync")
+}
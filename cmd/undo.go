@@ -0,0 +1,41 @@
+/*
+Copyright © 2023 Felix Geisendörfer
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/felixge/gh-stack/internal/stack"
+	"github.com/spf13/cobra"
+)
+
+// undoCmd represents the undo command
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Undo the last sync",
+	Long: `Undo the last operation that changed the stack, as recorded in the journal
+under .git/gh-stack/journal.
+
+The local branch, the pushed gh-stack-commit-<Commit-UID> branches and the
+title, body and base of the updated pull requests are restored to their state
+before the operation. Anything that was changed by someone else since is left
+alone and reported. Pull requests created by the operation are not closed, but
+deleting their branch usually closes them.`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		op, notes, err := stack.Undo(cmd.Context(), ctx)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "undid %s from %s\n", op.Name, op.Time.Format("2006-01-02 15:04:05"))
+		for _, note := range notes {
+			fmt.Fprintf(out, "  %s\n", note)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(undoCmd)
+}
@@ -0,0 +1,317 @@
+package stack
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// httpCache stores forge api responses under .git/gh-stack/cache, so they can
+// be revalidated with conditional requests. Unchanged responses are answered
+// with 304 Not Modified, which doesn't count against the rate limit of github.
+type httpCache struct {
+	dir string
+	log *slog.Logger
+}
+
+// httpCacheEntry is a cached response, stored as json in a file named after
+// the hash of the request, see httpCache.key.
+type httpCacheEntry struct {
+	URL    string
+	ETag   string
+	Header http.Header
+	Body   []byte
+}
+
+// httpCacheMaxAge is how long cached responses are kept after their last use.
+const httpCacheMaxAge = 14 * 24 * time.Hour
+
+// newHTTPCache returns the cache of the repository of the context, after
+// pruning the responses that weren't used for httpCacheMaxAge.
+func newHTTPCache(c *Context) (*httpCache, error) {
+	dir, err := gitCommonDir(c)
+	if err != nil {
+		return nil, err
+	}
+	h := &httpCache{dir: filepath.Join(dir, "gh-stack", "cache"), log: c.log}
+	if err := h.prune(time.Now()); err != nil {
+		c.log.Debug("failed to prune forge api cache", "err", err)
+	}
+	return h, nil
+}
+
+// prune removes the cached responses last used before now-httpCacheMaxAge,
+// e.g. those of branches that were merged long ago, and the temporary files
+// left behind by interrupted writes.
+func (h *httpCache) prune(now time.Time) error {
+	entries, err := os.ReadDir(h.dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || now.Sub(info.ModTime()) <= httpCacheMaxAge {
+			// files removed since reading the dir are skipped as well
+			continue
+		}
+		file := filepath.Join(h.dir, e.Name())
+		h.log.Debug("pruning cached response", "file", file)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// transport returns a round tripper that sends requests via base and caches
+// the responses of GET requests with an ETag. It returns base if h is nil.
+func (h *httpCache) transport(base http.RoundTripper) http.RoundTripper {
+	if h == nil {
+		return base
+	} else if base == nil {
+		base = http.DefaultTransport
+	}
+	return &cacheTransport{base: base, cache: h}
+}
+
+// Invalidate removes the cached pull request lookups of the given branch,
+// e.g. a branch whose head has changed, see cachedBranch.
+func (h *httpCache) Invalidate(branch string) error {
+	if h == nil {
+		return nil
+	}
+	return h.each(func(file string, entry *httpCacheEntry, err error) error {
+		if err == nil && cachedBranch(entry.URL) != branch {
+			return nil
+		}
+		h.log.Debug("invalidating cached response", "file", file, "err", err)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// each calls fn with every cached response, or the error reading it.
+func (h *httpCache) each(fn func(file string, entry *httpCacheEntry, err error) error) error {
+	files, err := filepath.Glob(filepath.Join(h.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		entry, err := h.read(file)
+		if err := fn(file, entry, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedBranch returns the branch whose pull requests are looked up by the
+// request for rawURL, from the head parameter of github, which is qualified
+// with the owner, or the source_branch parameter of gitlab. It returns "" for
+// other requests.
+func cachedBranch(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	query := u.Query()
+	if head := query.Get("head"); head != "" {
+		if _, branch, ok := strings.Cut(head, ":"); ok {
+			return branch
+		}
+		return head
+	}
+	return query.Get("source_branch")
+}
+
+// key returns the file the response to req is cached in.
+func (h *httpCache) key(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String() + "\x00" + req.Header.Get("Accept")))
+	return filepath.Join(h.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (h *httpCache) read(file string) (*httpCacheEntry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entry httpCacheEntry
+	return &entry, json.Unmarshal(data, &entry)
+}
+
+// write stores the entry atomically, so concurrent readers never see a
+// partial entry.
+func (h *httpCache) write(file string, entry *httpCacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to file and renames it
+// into place.
+func writeFileAtomic(file string, data []byte) error {
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// cacheTransport is the http.RoundTripper of httpCache.
+type cacheTransport struct {
+	base  http.RoundTripper
+	cache *httpCache
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	file := t.cache.key(req)
+	entry, err := t.cache.read(file)
+	if err == nil && entry.ETag != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", entry.ETag)
+	} else {
+		entry = nil
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotModified && entry != nil:
+		t.cache.log.Debug("using cached response", "url", req.URL.String())
+		res.Body.Close()
+		// the entry was used, see prune
+		now := time.Now()
+		_ = os.Chtimes(file, now, now)
+		// the headers of the 304 are fresher, e.g. the rate limit
+		header := entry.Header.Clone()
+		for key, values := range res.Header {
+			header[key] = values
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         res.Proto,
+			ProtoMajor:    res.ProtoMajor,
+			ProtoMinor:    res.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+			ContentLength: int64(len(entry.Body)),
+			Request:       req,
+		}, nil
+	case res.StatusCode == http.StatusOK && res.Header.Get("ETag") != "":
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		entry := &httpCacheEntry{URL: req.URL.String(), ETag: res.Header.Get("ETag"), Header: res.Header, Body: body}
+		if err := t.cache.write(file, entry); err != nil {
+			// the cache is an optimization, the response is fine without it
+			t.cache.log.Debug("failed to cache response", "url", req.URL.String(), "err", err)
+		}
+	}
+	return res, nil
+}
+
+// pullRequestSnapshot is the pull request data last loaded for each head by
+// the status stack, which is shown by offline status.
+type pullRequestSnapshot struct {
+	PullRequests map[string]*CachedPullRequest
+}
+
+// CachedPullRequest is the pull request of a head in the snapshot.
+type CachedPullRequest struct {
+	// PullRequest is nil if the head had no pull request.
+	PullRequest *PullRequest
+	FetchedAt   time.Time
+}
+
+// snapshotFile returns the file the pull request snapshot is stored in. It's
+// next to the cached responses, so Invalidate doesn't touch it.
+func (h *httpCache) snapshotFile() string {
+	return filepath.Join(filepath.Dir(h.dir), "pull_requests.json")
+}
+
+// SavePullRequests stores the given pull requests keyed by head in the
+// snapshot, replacing the entries of the same heads only, so the snapshot
+// covers every stack status was run for. Entries that weren't updated for
+// httpCacheMaxAge are dropped.
+func (h *httpCache) SavePullRequests(prs map[string]*PullRequest, fetchedAt time.Time) error {
+	if h == nil {
+		return nil
+	}
+	snapshot, err := h.LoadPullRequests()
+	if err != nil {
+		h.log.Debug("failed to load pull request snapshot, replacing it", "err", err)
+		snapshot = nil
+	}
+	if snapshot == nil {
+		snapshot = map[string]*CachedPullRequest{}
+	}
+	for head, entry := range snapshot {
+		if fetchedAt.Sub(entry.FetchedAt) > httpCacheMaxAge {
+			delete(snapshot, head)
+		}
+	}
+	for head, pr := range prs {
+		snapshot[head] = &CachedPullRequest{PullRequest: pr, FetchedAt: fetchedAt}
+	}
+	data, err := json.Marshal(pullRequestSnapshot{PullRequests: snapshot})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(h.snapshotFile(), data)
+}
+
+// LoadPullRequests returns the pull requests stored by SavePullRequests keyed
+// by head. It returns a nil map if there is no snapshot.
+func (h *httpCache) LoadPullRequests() (map[string]*CachedPullRequest, error) {
+	if h == nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(h.snapshotFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var snapshot pullRequestSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot.PullRequests, nil
+}
@@ -0,0 +1,94 @@
+package stack
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPCache(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+	_, err := env.Run("git", "init")
+	require.NoError(t, err)
+	c := newTestContext(env.Dir, Config{}.WithDefaults())
+	c.httpCache, err = newHTTPCache(c)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(env.Dir, ".git", "gh-stack", "cache"), c.httpCache.dir)
+
+	body := "v1"
+	var full, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + body + `"`
+		w.Header().Set("X-RateLimit-Remaining", "42")
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	client := newForgeHTTPClient(c, server.Client().Transport)
+	get := func(t *testing.T, path string) (string, http.Header) {
+		t.Helper()
+		res, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		data, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(data), res.Header
+	}
+
+	data, _ := get(t, "/pulls?head=gh-stack-commit-a")
+	require.Equal(t, "v1", data)
+	data, header := get(t, "/pulls?head=gh-stack-commit-a")
+	require.Equal(t, "v1", data)
+	require.Equal(t, "application/json", header.Get("Content-Type"))
+	require.Equal(t, "42", header.Get("X-RateLimit-Remaining"))
+	require.Equal(t, 1, full)
+	require.Equal(t, 1, notModified)
+
+	// changed responses are revalidated by the server
+	body = "v2"
+	data, _ = get(t, "/pulls?head=gh-stack-commit-a")
+	require.Equal(t, "v2", data)
+	require.Equal(t, 2, full)
+
+	// invalidated entries are fetched without condition, other branches
+	// with the same prefix stay cached
+	get(t, "/pulls?head=gh-stack-commit-b")
+	get(t, "/pulls?head=acme%3Agh-stack-commit-ab")
+	require.NoError(t, c.httpCache.Invalidate("gh-stack-commit-a"))
+	get(t, "/pulls?head=gh-stack-commit-a")
+	get(t, "/pulls?head=gh-stack-commit-b")
+	get(t, "/pulls?head=acme%3Agh-stack-commit-ab")
+	require.Equal(t, 5, full)
+	require.Equal(t, 3, notModified)
+
+	// entries that weren't used for a while are pruned
+	req, err := http.NewRequest("GET", server.URL+"/pulls?head=gh-stack-commit-b", nil)
+	require.NoError(t, err)
+	old := time.Now().Add(-httpCacheMaxAge - time.Hour)
+	require.NoError(t, os.Chtimes(c.httpCache.key(req), old, old))
+	require.NoError(t, c.httpCache.prune(time.Now()))
+	require.NoFileExists(t, c.httpCache.key(req))
+
+	// without a cache requests are sent as is
+	c.httpCache = nil
+	client = newForgeHTTPClient(c, server.Client().Transport)
+	get(t, "/pulls?head=gh-stack-commit-a")
+	require.Equal(t, 6, full)
+	require.NoError(t, c.httpCache.Invalidate("gh-stack-commit-a"))
+}
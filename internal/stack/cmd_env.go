@@ -2,10 +2,15 @@ package stack
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/exp/slog"
 )
@@ -14,6 +19,28 @@ type CmdEnv struct {
 	Dir    string
 	Env    []string
 	Logger *slog.Logger
+	// Stdin is passed to the command's standard input if it is not nil.
+	Stdin io.Reader
+	// Timeout is the maximum duration of a command, or 0 for no limit.
+	Timeout time.Duration
+
+	ctx context.Context
+}
+
+// WithContext returns a copy of the env whose commands are interrupted when
+// ctx is done.
+func (e CmdEnv) WithContext(ctx context.Context) CmdEnv {
+	e.ctx = ctx
+	return e
+}
+
+// Context returns the context of the env, see WithContext. It defaults to
+// the background context.
+func (e CmdEnv) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
 }
 
 func (e CmdEnv) RunMulti(cmds ...[]string) error {
@@ -26,33 +53,220 @@ func (e CmdEnv) RunMulti(cmds ...[]string) error {
 }
 
 func (e CmdEnv) Run(command ...string) (string, error) {
+	return e.run(command, io.Discard)
+}
+
+// RunLogged runs the command like Run, but also logs each line of its output
+// at info level as soon as it is written, so the user can follow commands
+// that take a while, e.g. a push of many branches.
+func (e CmdEnv) RunLogged(command ...string) (string, error) {
+	if e.Logger == nil {
+		return e.Run(command...)
+	}
+	w := &lineLogger{log: e.Logger, cmd: strings.Join(command, " ")}
+	defer w.Flush()
+	return e.run(command, w)
+}
+
+// RunInteractive runs the command attached to the standard input, output and
+// error of the process, so the user can interact with it, e.g. to edit the
+// todo list of git rebase --interactive. Its output isn't captured, so
+// errors don't include it, and Timeout doesn't apply, as the command waits
+// for the user.
+func (e CmdEnv) RunInteractive(command ...string) error {
+	if e.Logger != nil {
+		e.Logger.Debug("exec interactive", "cmd", strings.Join(command, " "))
+	}
+	e.Timeout = 0
+	cmd, ctx, cancel, err := e.command(command)
+	if err != nil {
+		return newCmdError(command, "", "", err)
+	}
+	defer cancel()
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); ctx.Err() != nil {
+		return e.interrupted(ctx, command)
+	} else if err != nil {
+		return newCmdError(command, "", "", err)
+	}
+	return nil
+}
+
+// run runs the command and returns its combined output, which is also
+// written to out while the command is running.
+func (e CmdEnv) run(command []string, out io.Writer) (string, error) {
+	cmdS := strings.Join(command, " ")
+	if e.Logger != nil {
+		e.Logger.Debug("exec", "cmd", cmdS)
+	}
+
+	// stdout and stderr are combined in buf, stderr is also kept on its own
+	// for GitError
+	var buf, stderr bytes.Buffer
+	wrapErr := func(err error) error {
+		return newCmdError(command, buf.String(), stderr.String(), err)
+	}
+
+	cmd, ctx, cancel, err := e.command(command)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	defer cancel()
+	// the writes of stdout and stderr happen concurrently
+	var mu sync.Mutex
+	cmd.Stdout = lockedWriter{&mu, io.MultiWriter(&buf, out)}
+	cmd.Stderr = lockedWriter{&mu, io.MultiWriter(&buf, &stderr, out)}
+	if err := cmd.Run(); ctx.Err() != nil {
+		return "", e.interrupted(ctx, command)
+	} else if err != nil {
+		return "", wrapErr(err)
+	}
+	return buf.String(), nil
+}
+
+// Stream runs the command like Run, but passes its standard output to fn
+// while the command is running instead of buffering it. If fn returns an
+// error, the command is killed. Standard error is included in the returned
+// error.
+func (e CmdEnv) Stream(fn func(io.Reader) error, command ...string) error {
 	cmdS := strings.Join(command, " ")
 	if e.Logger != nil {
 		e.Logger.Debug("exec", "cmd", cmdS)
 	}
 
-	var buf bytes.Buffer
+	var stderr bytes.Buffer
 	wrapErr := func(err error) error {
-		if buf.String() == "" {
-			return fmt.Errorf("%s: %w", cmdS, err)
+		return newCmdError(command, stderr.String(), stderr.String(), err)
+	}
+
+	cmd, ctx, cancel, err := e.command(command)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer cancel()
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return wrapErr(err)
+	}
+	if err := cmd.Start(); err != nil {
+		return wrapErr(err)
+	}
+	if err := fn(stdout); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if ctx.Err() != nil {
+			return e.interrupted(ctx, command)
+		} else if stderr.Len() > 0 {
+			// the command may have failed first and caused fn's error
+			return wrapErr(err)
 		}
-		return fmt.Errorf("%s: %s: %w", cmdS, &buf, err)
+		return err
 	}
+	// drain the output fn didn't consume, so the command doesn't block
+	if _, err := io.Copy(io.Discard, stdout); err != nil {
+		_ = cmd.Wait()
+		return wrapErr(err)
+	}
+	if err := cmd.Wait(); ctx.Err() != nil {
+		return e.interrupted(ctx, command)
+	} else if err != nil {
+		return wrapErr(err)
+	}
+	return nil
+}
 
-	cmd := exec.Command(command[0], command[1:]...)
+// command returns the command to run along with its context, which is done
+// when the context of the env is done or the timeout has passed. The cancel
+// function must be called once the command has finished.
+func (e CmdEnv) command(command []string) (*exec.Cmd, context.Context, context.CancelFunc, error) {
+	ctx, cancel := e.Context(), context.CancelFunc(func() {})
+	if e.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+	}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	// interrupt the command like ctrl-c does, so git can remove its lock
+	// files and stop its own child processes, and only kill it if it doesn't
+	// exit in time
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = cmdWaitDelay
 	cmd.Dir = e.Dir
 	if e.Dir == "" {
 		wd, err := os.Getwd()
 		if err != nil {
-			return "", wrapErr(err)
+			cancel()
+			return nil, nil, nil, err
 		}
 		cmd.Dir = wd
 	}
 	cmd.Env = append(os.Environ(), e.Env...)
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
-	if err := cmd.Run(); err != nil {
-		return "", wrapErr(err)
+	cmd.Stdin = e.Stdin
+	return cmd, ctx, cancel, nil
+}
+
+// cmdWaitDelay is how long an interrupted command may take to exit before it
+// is killed.
+const cmdWaitDelay = 5 * time.Second
+
+// interrupted returns the error of a command that was interrupted because
+// ctx is done. It wraps the error of ctx, so it can be checked with
+// errors.Is.
+func (e CmdEnv) interrupted(ctx context.Context, command []string) error {
+	cmdS := strings.Join(command, " ")
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && e.Context().Err() == nil {
+		return fmt.Errorf("%s: timed out after %s: %w", cmdS, e.Timeout, ctx.Err())
+	}
+	return fmt.Errorf("%s: interrupted: %w", cmdS, ctx.Err())
+}
+
+// lockedWriter serializes the writes of several writers sharing a buffer.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// lineLogger is an io.Writer that logs each line written to it. Lines end
+// with "\n" or "\r", which git uses to redraw progress output in place.
+type lineLogger struct {
+	log  *slog.Logger
+	cmd  string
+	line []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.line = append(l.line, p...)
+	for {
+		i := bytes.IndexAny(l.line, "\r\n")
+		if i < 0 {
+			return len(p), nil
+		}
+		l.logLine(l.line[:i])
+		l.line = l.line[i+1:]
+	}
+}
+
+// Flush logs the last line if it wasn't terminated.
+func (l *lineLogger) Flush() {
+	l.logLine(l.line)
+	l.line = nil
+}
+
+func (l *lineLogger) logLine(line []byte) {
+	if s := strings.TrimSpace(string(line)); s != "" {
+		l.log.Info(s, "cmd", l.cmd)
 	}
-	return buf.String(), nil
 }
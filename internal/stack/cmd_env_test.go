@@ -0,0 +1,90 @@
+package stack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+)
+
+func TestCmdEnvContext(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		env := CmdEnv{Timeout: 50 * time.Millisecond}
+		start := time.Now()
+		_, err := env.Run("sleep", "10")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.EqualError(t, err, "sleep 10: timed out after 50ms: context deadline exceeded")
+		require.Less(t, time.Since(start), cmdWaitDelay)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		env := CmdEnv{}.WithContext(ctx)
+		time.AfterFunc(50*time.Millisecond, cancel)
+		err := env.Stream(func(r io.Reader) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		}, "sh", "-c", "echo start; exec sleep 10")
+		require.ErrorIs(t, err, context.Canceled)
+		require.EqualError(t, err, "sh -c echo start; exec sleep 10: interrupted: context canceled")
+	})
+
+	t.Run("done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := CmdEnv{Timeout: time.Minute}.WithContext(ctx).Run("true")
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, ctx, CmdEnv{}.WithContext(ctx).Context())
+		require.Equal(t, context.Background(), CmdEnv{}.Context())
+	})
+}
+
+func TestCmdEnvStream(t *testing.T) {
+	errParse := errors.New("parse error")
+	err := CmdEnv{}.Stream(func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		require.NoError(t, err)
+		return errParse
+	}, "sh", "-c", "echo partial; echo failed >&2")
+	require.ErrorIs(t, err, errParse)
+	require.EqualError(t, err, "sh -c echo partial; echo failed >&2: failed\n: parse error")
+
+	err = CmdEnv{}.Stream(func(io.Reader) error { return errParse }, "true")
+	require.Equal(t, errParse, err)
+}
+
+func TestCmdEnvRunLogged(t *testing.T) {
+	var logs bytes.Buffer
+	opt := slog.HandlerOptions{ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}}
+	env := CmdEnv{Logger: slog.New(opt.NewTextHandler(&logs))}
+	out, err := env.RunLogged("printf", `one\n50%%\r100%%\rlast`)
+	require.NoError(t, err)
+	require.Equal(t, "one\n50%\r100%\rlast", out)
+	require.Equal(t, strings.Join([]string{
+		`level=INFO msg=one cmd="printf one\\n50%%\\r100%%\\rlast"`,
+		`level=INFO msg=50% cmd="printf one\\n50%%\\r100%%\\rlast"`,
+		`level=INFO msg=100% cmd="printf one\\n50%%\\r100%%\\rlast"`,
+		`level=INFO msg=last cmd="printf one\\n50%%\\r100%%\\rlast"`,
+	}, "\n")+"\n", logs.String())
+
+	_, err = env.RunLogged("sh", "-c", "echo failed >&2; exit 3")
+	require.EqualError(t, err, "sh -c echo failed >&2; exit 3: failed\n: exit status 3")
+}
+
+func TestCmdEnvRunInteractive(t *testing.T) {
+	env := CmdEnv{Stdin: strings.NewReader("yes\n"), Timeout: time.Nanosecond}
+	// the timeout doesn't apply to interactive commands
+	require.NoError(t, env.RunInteractive("sh", "-c", `read answer && test "$answer" = yes`))
+	require.EqualError(t, env.RunInteractive("false"), "false: exit status 1")
+}
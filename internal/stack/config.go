@@ -1,9 +1,16 @@
 package stack
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
 	"golang.org/x/exp/slog"
 	"gopkg.in/yaml.v3"
@@ -14,25 +21,66 @@ type Config struct {
 	Verbose bool `yaml:"verbose"`
 	// LocalHead is a git reference to the local top of the stack, defaults to "HEAD".
 	LocalHead string `yaml:"local_head"`
-	// RemoteHost is the name of the remote host, defaults to "github.com".
+	// RemoteHost is the name of the remote host, defaults to the host of the
+	// remote url or "github.com".
 	RemoteHost string `yaml:"remote_host"`
 	// RemoteName is the name of the remote repository to target,
 	// defaults to "origin".
 	RemoteName string `yaml:"remote_name"`
-	// RemoteHead is the name of the remote branch to target,
-	// defaults to "main".
+	// RemoteHead is the name of the remote branch to target, defaults to the
+	// branch detected by LoadRemoteHead.
 	RemoteHead string `yaml:"remote_head"`
-	// GithubOAuthToken holds the github OAuth token. Uses the gh cli token if
-	// available.
-	GithubOAuthToken string `yaml:""`
+	// Token is the token used to authenticate with the forge api. See
+	// resolveToken for the sources it is loaded from.
+	Token string `yaml:"token" secret:"true"`
+	// UIDTrailers are the keys of the commit message trailers that identify
+	// a commit, in order of precedence, defaults to ["Commit-UID"]. Adding
+	// "Change-Id" lets commits created with the commit-msg hook of gerrit
+	// count as identified.
+	UIDTrailers []string `yaml:"uid_trailers"`
+	// UIDGenerator selects how new commit UIDs are generated, see
+	// NewUIDGenerator. Defaults to "random".
+	UIDGenerator string `yaml:"uid_generator"`
+	// Forge is the kind of code hosting service of the remote repository,
+	// either "github", "gitlab" or "gitea" (which includes forgejo). Defaults
+	// to the forge suggested by RemoteHost, see forgeForHost.
+	Forge string `yaml:"forge"`
 	// RemoteOwner is the name of the owner (user or org) of the remote
-	// repository.
-	RemoteOwner string `yaml:"remote_host"`
-	// RemoteRepo is the name of the remote repository.
-	RemoteRepo string `yaml:"remote_host"`
+	// repository, defaults to the owner found in the remote url.
+	RemoteOwner string `yaml:"remote_owner"`
+	// RemoteRepo is the name of the remote repository, defaults to the
+	// repository found in the remote url.
+	RemoteRepo string `yaml:"remote_repo"`
+	// PushRemoteName is the name of the remote the commit branches are pushed
+	// to, defaults to RemoteName. Pointing it to a fork enables the fork
+	// workflow, where pull requests are opened from the fork against the
+	// remote repository.
+	PushRemoteName string `yaml:"push_remote_name"`
+	// PushOwner is the name of the owner of the push remote repository,
+	// defaults to the owner found in the push remote url or RemoteOwner.
+	PushOwner string `yaml:"push_owner"`
+	// PushRepo is the name of the push remote repository, defaults to the
+	// repository found in the push remote url or RemoteRepo.
+	PushRepo string `yaml:"push_repo"`
+	// Concurrency is the maximum number of concurrent forge api requests,
+	// defaults to 8.
+	Concurrency int `yaml:"concurrency"`
+	// Timeout is the maximum duration of a git command or forge api request,
+	// e.g. "90s", defaults to 10m. It keeps hung fetches and credential
+	// prompts from blocking forever.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// sources maps the yaml keys of the settings above to the layer they were
+	// loaded from.
+	sources map[string]string
 }
 
+// Load loads the config from ~/.gh-stack.yml and <gitroot>/.gh-stack.yml.
+// Settings in later files override earlier ones. Unknown keys are rejected.
 func (c *Config) Load(ctx *Context) error {
+	if _, err := configKeys(reflect.TypeOf(*c)); err != nil {
+		return err
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return err
@@ -42,11 +90,10 @@ func (c *Config) Load(ctx *Context) error {
 		return err
 	}
 
-	*c = c.WithDefaults()
 	for _, dir := range []string{home, gitRoot} {
 		path := filepath.Join(dir, ".gh-stack.yml")
 		data, err := ioutil.ReadFile(path)
-		if os.IsNotExist(err); err != nil {
+		if os.IsNotExist(err) {
 			ctx.log.Debug("config file does not exist, skipping", "path", path)
 			continue
 		} else if err != nil {
@@ -54,27 +101,154 @@ func (c *Config) Load(ctx *Context) error {
 		}
 
 		ctx.log.Debug("loaded config file", "path", path)
-		if err := yaml.Unmarshal(data, c); err != nil {
-			return err
+		if err := c.unmarshal(data, path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
 		}
 	}
-	ctx.log.Debug("final config", "config", slog.AnyValue(c))
+	return nil
+}
+
+// unmarshal strictly decodes the yaml data into c and records source as the
+// layer of all settings it contains.
+func (c *Config) unmarshal(data []byte, source string) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); errors.Is(err, io.EOF) {
+		return nil
+	} else if err != nil {
+		return err
+	}
 
+	var keys map[string]interface{}
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	for key := range keys {
+		c.setSource(key, source)
+	}
 	return nil
 }
 
+// setDefault sets the setting with the given yaml key to value unless it is
+// already set or value is empty.
+func (c *Config) setDefault(field *string, key, value, source string) {
+	if *field != "" || value == "" {
+		return
+	}
+	*field = value
+	c.setSource(key, source)
+}
+
+// setSource records the layer the setting with the given yaml key was loaded
+// from.
+func (c *Config) setSource(key, source string) {
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+	c.sources[key] = source
+}
+
+// ConfigSetting is a single setting of the effective config.
+type ConfigSetting struct {
+	// Key is the yaml key of the setting.
+	Key string
+	// Value is the value of the setting, secrets are redacted.
+	Value string
+	// Source is the layer the setting was loaded from, or "" if it is unset.
+	Source string
+}
+
+// Settings returns all settings of the config in declaration order. It fails
+// if the struct tags of Config are invalid, see configKeys.
+func (c Config) Settings() ([]ConfigSetting, error) {
+	v := reflect.ValueOf(c)
+	keys, err := configKeys(v.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []ConfigSetting
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		setting := ConfigSetting{
+			Key:    key,
+			Value:  fmt.Sprint(v.Field(i).Interface()),
+			Source: c.sources[key],
+		}
+		if v.Type().Field(i).Tag.Get("secret") == "true" && setting.Value != "" {
+			setting.Value = "<redacted>"
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// LogValue implements slog.LogValuer to keep secrets out of the logs.
+func (c Config) LogValue() slog.Value {
+	settings, err := c.Settings()
+	if err != nil {
+		return slog.StringValue("invalid config: " + err.Error())
+	}
+	var attrs []slog.Attr
+	for _, s := range settings {
+		attrs = append(attrs, slog.String(s.Key, s.Value))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// configKeys returns the yaml key of each field of the given struct type, or
+// "" for unexported fields. Exported fields without an explicit key and
+// duplicate keys are reported as errors, as yaml would otherwise silently
+// derive a key or fail at decode time.
+func configKeys(t reflect.Type) ([]string, error) {
+	keys := make([]string, t.NumField())
+	seen := map[string]string{}
+	for i := range keys {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "" {
+			return nil, fmt.Errorf("config field %s has no yaml key", field.Name)
+		} else if other, ok := seen[key]; ok {
+			return nil, fmt.Errorf("config fields %s and %s have the same yaml key %q", other, field.Name, key)
+		}
+		seen[key] = field.Name
+		keys[i] = key
+	}
+	return keys, nil
+}
+
 func (c Config) WithDefaults() Config {
-	if c.LocalHead == "" {
-		c.LocalHead = "HEAD"
+	sources := c.sources
+	c.sources = nil
+	for key, source := range sources {
+		c.setSource(key, source)
+	}
+	c.setDefault(&c.LocalHead, "local_head", "HEAD", "default")
+	c.setDefault(&c.RemoteHost, "remote_host", "github.com", "default")
+	c.setDefault(&c.RemoteName, "remote_name", "origin", "default")
+	c.setDefault(&c.Forge, "forge", forgeForHost(c.RemoteHost), "remote_host")
+	c.setDefault(&c.UIDGenerator, "uid_generator", "random", "default")
+	if len(c.UIDTrailers) == 0 {
+		c.UIDTrailers = []string{"Commit-UID"}
+		c.setSource("uid_trailers", "default")
 	}
-	if c.RemoteHost == "" {
-		c.RemoteHost = "github.com"
+	if c.Concurrency <= 0 {
+		c.Concurrency = 8
+		c.setSource("concurrency", "default")
 	}
-	if c.RemoteName == "" {
-		c.RemoteName = "origin"
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Minute
+		c.setSource("timeout", "default")
 	}
-	if c.RemoteHead == "" {
-		c.RemoteHead = "main"
+	c.setDefault(&c.PushRemoteName, "push_remote_name", c.RemoteName, "default")
+	if c.PushRemoteName == c.RemoteName {
+		c.setDefault(&c.PushOwner, "push_owner", c.RemoteOwner, "default")
+		c.setDefault(&c.PushRepo, "push_repo", c.RemoteRepo, "default")
 	}
 	return c
 }
@@ -82,3 +256,18 @@ func (c Config) WithDefaults() Config {
 func (c Config) RemoteRef() string {
 	return c.RemoteName + "/" + c.RemoteHead
 }
+
+// IsFork returns true if commit branches are pushed to a different repository
+// than the one pull requests are opened in.
+func (c Config) IsFork() bool {
+	return c.PushOwner != c.RemoteOwner || c.PushRepo != c.RemoteRepo
+}
+
+// PullRequestHead returns the head of the pull request for the given branch
+// of the push remote. It is qualified with the owner in the fork workflow.
+func (c Config) PullRequestHead(branch string) string {
+	if c.IsFork() {
+		return c.PushOwner + ":" + branch
+	}
+	return branch
+}
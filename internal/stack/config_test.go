@@ -0,0 +1,51 @@
+package stack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	t.Run("configKeys", func(t *testing.T) {
+		_, err := configKeys(reflect.TypeOf(Config{}))
+		require.NoError(t, err)
+
+		_, err = configKeys(reflect.TypeOf(struct {
+			A string `yaml:"a"`
+			B string `yaml:"a"`
+		}{}))
+		require.EqualError(t, err, `config fields A and B have the same yaml key "a"`)
+
+		_, err = configKeys(reflect.TypeOf(struct {
+			A string `yaml:",omitempty"`
+		}{}))
+		require.EqualError(t, err, `config field A has no yaml key`)
+	})
+
+	t.Run("unmarshal", func(t *testing.T) {
+		var c Config
+		require.NoError(t, c.unmarshal([]byte("remote_name: upstream\n"), "user"))
+		require.NoError(t, c.unmarshal([]byte("remote_name: fork\ntoken: secret\n"), "repo"))
+		require.NoError(t, c.unmarshal(nil, "empty"))
+		c = c.WithDefaults()
+
+		all, err := c.Settings()
+		require.NoError(t, err)
+		settings := map[string]ConfigSetting{}
+		for _, s := range all {
+			settings[s.Key] = s
+		}
+		require.Equal(t, ConfigSetting{Key: "remote_name", Value: "fork", Source: "repo"}, settings["remote_name"])
+		require.Equal(t, ConfigSetting{Key: "token", Value: "<redacted>", Source: "repo"}, settings["token"])
+		require.Equal(t, ConfigSetting{Key: "local_head", Value: "HEAD", Source: "default"}, settings["local_head"])
+		require.Equal(t, ConfigSetting{Key: "remote_owner", Value: "", Source: ""}, settings["remote_owner"])
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		var c Config
+		err := c.unmarshal([]byte("remote_nmae: upstream\n"), "user")
+		require.ErrorContains(t, err, "field remote_nmae not found")
+	})
+}
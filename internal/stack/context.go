@@ -1,6 +1,8 @@
 package stack
 
 import (
+	"context"
+	"errors"
 	"os"
 
 	"golang.org/x/exp/slog"
@@ -13,20 +15,30 @@ type ContextOptions struct {
 	Verbose bool
 	// LoadConfig determines if the context should load its config from disk.
 	LoadConfig bool
-	// LoadGithubCredentials determines if the github credentials should be
-	// loaded automatically.
-	LoadGithub bool
+	// LoadForge determines if the forge credentials should be loaded and its
+	// api client be created.
+	LoadForge bool
+	// SkipMergeBase skips computing the merge base, for commands that only
+	// inspect the config.
+	SkipMergeBase bool
+	// Offline avoids the network: the forge is not loaded regardless of
+	// LoadForge, and remotes are not fetched.
+	Offline bool
 }
 
-func (o ContextOptions) NewContext() (*Context, error) {
-	c := &Context{}
+// NewContext creates a context. The commands and forge requests it runs,
+// here and in the operations it is passed to, are interrupted when ctx is
+// done.
+func (o ContextOptions) NewContext(ctx context.Context) (*Context, error) {
+	c := &Context{logLevel: new(slog.LevelVar)}
 	c.cmd.Dir = o.Dir
+	c.cmd = c.cmd.WithContext(ctx)
 	if o.Verbose {
 		c.logLevel.Set(slog.LevelDebug)
 	} else {
 		c.logLevel.Set(slog.LevelInfo)
 	}
-	slogOpt := slog.HandlerOptions{Level: &c.logLevel}
+	slogOpt := slog.HandlerOptions{Level: c.logLevel}
 	c.log = slog.New(slogOpt.NewTextHandler(os.Stdout))
 	c.cmd.Logger = c.log
 
@@ -34,22 +46,44 @@ func (o ContextOptions) NewContext() (*Context, error) {
 		if err := c.config.Load(c); err != nil {
 			return nil, err
 		}
-	} else {
-		c.config = c.config.WithDefaults()
+	}
+	if err := c.config.LoadRemote(c); err != nil {
+		return nil, err
+	}
+	c.config = c.config.WithDefaults()
+	var err error
+	if c.uids, err = NewUIDGenerator(c.config.UIDGenerator); err != nil {
+		return nil, err
 	}
 	if c.config.Verbose {
 		c.logLevel.Set(slog.LevelDebug)
 	}
+	c.cmd.Timeout = c.config.Timeout
 
-	if o.LoadGithub && c.config.GithubOAuthToken == "" {
-		gh, err := readGhCLIConfig()
-		if err != nil {
+	c.offline = o.Offline
+	if o.LoadForge || o.Offline {
+		if c.httpCache, err = newHTTPCache(c); err != nil {
+			c.log.Debug("failed to locate forge api cache, not caching", "err", err)
+		}
+	}
+	if o.LoadForge && !o.Offline {
+		if err := c.loadToken(); err != nil {
+			return nil, err
+		}
+		if c.forge, err = newForge(c); err != nil {
 			return nil, err
 		}
-		c.config.GithubOAuthToken = (*gh)[c.config.RemoteHost].OauthToken
 	}
 
-	var err error
+	if err := c.config.LoadRemoteHead(c); err != nil {
+		return nil, err
+	}
+	c.log.Debug("final config", "config", slog.AnyValue(c.config))
+	if o.SkipMergeBase {
+		return c, nil
+	} else if c.config.RemoteHead == "" {
+		return nil, errors.New("failed to detect the target branch, please configure remote_head")
+	}
 	c.mergeBase, err = MergeBase(c.cmd, c.config.LocalHead, c.config.RemoteRef())
 	if err != nil {
 		return nil, err
@@ -62,6 +96,45 @@ type Context struct {
 	config    Config
 	cmd       CmdEnv
 	log       *slog.Logger
-	logLevel  slog.LevelVar
+	logLevel  *slog.LevelVar
 	mergeBase string
+	forge     Forge
+	httpCache *httpCache
+	uids      UIDGenerator
+	offline   bool
+}
+
+// withContext returns a copy of c whose commands are interrupted when ctx is
+// done. Operations that take a context.Context use it for all commands they
+// run.
+// LoadToken resolves the forge token like NewContext does with LoadForge, but
+// leaves it unset instead of failing if none can be found. It is meant for
+// commands that only show the config.
+func (c *Context) LoadToken() {
+	if err := c.loadToken(); err != nil {
+		c.log.Debug("failed to resolve forge token, leaving it unset", "err", err)
+	}
+}
+
+// loadToken resolves the forge token and records its source.
+func (c *Context) loadToken() error {
+	token, source, err := resolveToken(c)
+	if err != nil {
+		return err
+	}
+	c.config.Token = token
+	c.config.setSource("token", source)
+	c.log.Debug("forge token", "forge", c.config.Forge, "source", source)
+	return nil
+}
+
+func (c *Context) withContext(ctx context.Context) *Context {
+	cp := *c
+	cp.cmd = c.cmd.WithContext(ctx)
+	return &cp
+}
+
+// Config returns the effective config of the context.
+func (c *Context) Config() Config {
+	return c.config
 }
@@ -0,0 +1,119 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// resolveToken returns the forge api token for the configured remote host and
+// a description of the source it was found in. The sources are tried in the
+// following order:
+//
+//  1. The GH_STACK_TOKEN environment variable, followed by GH_TOKEN and
+//     GITHUB_TOKEN for github.com, GH_ENTERPRISE_TOKEN for other github hosts,
+//     GITLAB_TOKEN for gitlab and GITEA_TOKEN for gitea.
+//  2. The output of `gh auth token --hostname <host>` for github.
+//  3. The password returned by `git credential fill`.
+//  4. The token setting of the gh-stack config, followed by the hosts.yml file
+//     of the gh cli for github.
+func resolveToken(c *Context) (token string, source string, err error) {
+	host := c.config.RemoteHost
+	github := c.config.Forge == "github"
+
+	envVars := []string{"GH_STACK_TOKEN"}
+	switch {
+	case c.config.Forge == "gitlab":
+		envVars = append(envVars, "GITLAB_TOKEN")
+	case c.config.Forge == "gitea":
+		envVars = append(envVars, "GITEA_TOKEN")
+	case host == "github.com":
+		envVars = append(envVars, "GH_TOKEN", "GITHUB_TOKEN")
+	case github:
+		envVars = append(envVars, "GH_ENTERPRISE_TOKEN")
+	}
+	for _, name := range envVars {
+		if token := os.Getenv(name); token != "" {
+			return token, "env:" + name, nil
+		}
+	}
+
+	if !github {
+		// the gh cli only knows about github hosts
+	} else if out, err := c.cmd.Run("gh", "auth", "token", "--hostname", host); err != nil {
+		c.log.Debug("gh auth token failed, skipping", "err", err)
+	} else if token := strings.TrimSpace(out); token != "" {
+		return token, "gh auth token", nil
+	}
+
+	if token, err := gitCredentialFill(c.cmd, host); err != nil {
+		c.log.Debug("git credential fill failed, skipping", "err", err)
+	} else if token != "" {
+		return token, "git credential", nil
+	}
+
+	if c.config.Token != "" {
+		return c.config.Token, "gh-stack config", nil
+	} else if !github {
+		return "", "", fmt.Errorf("no %s token found for %s: set GH_STACK_TOKEN or configure token", c.config.Forge, host)
+	}
+	gh, err := readGhCLIConfig()
+	if errors.Is(err, fs.ErrNotExist) {
+		c.log.Debug("gh cli config does not exist, skipping", "err", err)
+	} else if err != nil {
+		return "", "", err
+	} else if token := (*gh)[host].OauthToken; token != "" {
+		return token, "gh hosts.yml", nil
+	}
+
+	return "", "", fmt.Errorf("no github token found for %s: set GH_TOKEN or run `gh auth login`", host)
+}
+
+// gitCredentialFill asks the git credential helpers for the password of the
+// given host without prompting the user.
+func gitCredentialFill(env CmdEnv, host string) (string, error) {
+	env.Stdin = strings.NewReader("protocol=https\nhost=" + host + "\n\n")
+	env.Env = append(append([]string{}, env.Env...), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	out, err := env.Run("git", "credential", "fill")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if password, ok := strings.CutPrefix(line, "password="); ok {
+			return strings.TrimSpace(password), nil
+		}
+	}
+	return "", nil
+}
+
+// gh cli config (https://cli.github.com)
+type ghCLIConfig map[string]struct {
+	User        string `yaml:"user"`
+	OauthToken  string `yaml:"oauth_token"`
+	GitProtocol string `yaml:"git_protocol"`
+}
+
+func readGhCLIConfig() (*ghCLIConfig, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	f, err := os.Open(path.Join(homeDir, ".config", "gh", "hosts.yml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gh cli config file: %w", err)
+	}
+	defer f.Close()
+
+	var cfg ghCLIConfig
+	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse hub config file: %w", err)
+	}
+
+	return &cfg, nil
+}
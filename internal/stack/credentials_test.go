@@ -0,0 +1,102 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveToken(t *testing.T) {
+	// isolate the test from the credentials of the user running it
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, name := range []string{"GH_STACK_TOKEN", "GH_TOKEN", "GITHUB_TOKEN", "GH_ENTERPRISE_TOKEN", "GITLAB_TOKEN"} {
+		t.Setenv(name, "")
+	}
+	bin := t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	fakeGh := func(t *testing.T, script string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(filepath.Join(bin, "gh"), []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	}
+	fakeGh(t, "exit 1")
+
+	resolve := func(t *testing.T, host string) (string, string, error) {
+		t.Helper()
+		config := Config{RemoteHost: host}.WithDefaults()
+		return resolveToken(newTestContext(home, config))
+	}
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("GITHUB_TOKEN", "github-token")
+		t.Setenv("GH_TOKEN", "gh-token")
+		token, source, err := resolve(t, "github.com")
+		require.NoError(t, err)
+		require.Equal(t, "gh-token", token)
+		require.Equal(t, "env:GH_TOKEN", source)
+
+		t.Setenv("GH_STACK_TOKEN", "gh-stack-token")
+		token, source, err = resolve(t, "github.com")
+		require.NoError(t, err)
+		require.Equal(t, "gh-stack-token", token)
+		require.Equal(t, "env:GH_STACK_TOKEN", source)
+	})
+
+	t.Run("env enterprise", func(t *testing.T) {
+		t.Setenv("GH_TOKEN", "gh-token")
+		t.Setenv("GH_ENTERPRISE_TOKEN", "enterprise-token")
+		token, source, err := resolve(t, "github.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "enterprise-token", token)
+		require.Equal(t, "env:GH_ENTERPRISE_TOKEN", source)
+	})
+
+	t.Run("env gitlab", func(t *testing.T) {
+		t.Setenv("GH_TOKEN", "gh-token")
+		t.Setenv("GITLAB_TOKEN", "gitlab-token")
+		token, source, err := resolve(t, "gitlab.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "gitlab-token", token)
+		require.Equal(t, "env:GITLAB_TOKEN", source)
+	})
+
+	t.Run("gh auth token", func(t *testing.T) {
+		fakeGh(t, `[ "$*" = "auth token --hostname github.com" ] && echo gh-cli-token`)
+		defer fakeGh(t, "exit 1")
+		token, source, err := resolve(t, "github.com")
+		require.NoError(t, err)
+		require.Equal(t, "gh-cli-token", token)
+		require.Equal(t, "gh auth token", source)
+	})
+
+	t.Run("gh hosts.yml", func(t *testing.T) {
+		dir := filepath.Join(home, ".config", "gh")
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		hosts := "github.com:\n  user: foo\n  oauth_token: hosts-token\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "hosts.yml"), []byte(hosts), 0644))
+		defer os.RemoveAll(dir)
+
+		token, source, err := resolve(t, "github.com")
+		require.NoError(t, err)
+		require.Equal(t, "hosts-token", token)
+		require.Equal(t, "gh hosts.yml", source)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, _, err := resolve(t, "github.com")
+		require.ErrorContains(t, err, "no github token found for github.com")
+	})
+
+	t.Run("LoadToken", func(t *testing.T) {
+		c := newTestContext(home, Config{RemoteHost: "github.com"}.WithDefaults())
+		c.LoadToken()
+		require.Empty(t, c.config.Token)
+
+		t.Setenv("GH_STACK_TOKEN", "gh-stack-token")
+		c.LoadToken()
+		require.Equal(t, "gh-stack-token", c.config.Token)
+	})
+}
@@ -0,0 +1,164 @@
+package stack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Forge is the api of the code hosting service pull requests are opened on.
+type Forge interface {
+	// FindPullRequest returns the open pull request for the given head, or
+	// nil if there is none.
+	FindPullRequest(ctx context.Context, head string) (*PullRequest, error)
+	// CreatePullRequest opens the given pull request and sets its Number and
+	// URL.
+	CreatePullRequest(ctx context.Context, pr *PullRequest) error
+	// UpdatePullRequest updates the title, body and base of the given pull
+	// request.
+	UpdatePullRequest(ctx context.Context, pr *PullRequest) error
+	// LoadStatus sets the CI and Review status of the given pull request.
+	LoadStatus(ctx context.Context, pr *PullRequest) error
+	// DefaultBranch returns the default branch of the remote repository.
+	DefaultBranch(ctx context.Context) (string, error)
+	// CommitURL returns the web url of the given commit in the given
+	// repository.
+	CommitURL(owner, repo, hash string) string
+}
+
+// batchForge is implemented by forges that can find the pull requests of many
+// heads at once, see StatusStack.LoadPullRequests.
+type batchForge interface {
+	// FindPullRequests returns the open pull requests of the given heads
+	// keyed by head, with their CI and Review status loaded. Heads without a
+	// pull request are missing from the map.
+	FindPullRequests(ctx context.Context, heads []string) (map[string]*PullRequest, error)
+}
+
+// CIStatus is the combined status of the CI checks of a pull request.
+type CIStatus string
+
+const (
+	// CINone means that no CI checks have been reported.
+	CINone CIStatus = ""
+	// CIPending means that at least one check is still running and none
+	// have failed.
+	CIPending CIStatus = "pending"
+	// CISuccess means that all checks have passed.
+	CISuccess CIStatus = "success"
+	// CIFailure means that at least one check has failed.
+	CIFailure CIStatus = "failure"
+)
+
+// combineCI returns the combined status of the given statuses.
+func combineCI(statuses ...CIStatus) CIStatus {
+	combined := CINone
+	for _, s := range statuses {
+		switch {
+		case s == CIFailure:
+			return CIFailure
+		case s == CIPending:
+			combined = CIPending
+		case s == CISuccess && combined == CINone:
+			combined = CISuccess
+		}
+	}
+	return combined
+}
+
+// ReviewStatus is the review status of a pull request.
+type ReviewStatus string
+
+const (
+	// ReviewPending means that the pull request has not been approved yet.
+	ReviewPending ReviewStatus = "pending"
+	// ReviewApproved means that the pull request has been approved.
+	ReviewApproved ReviewStatus = "approved"
+	// ReviewChangesRequested means that a reviewer has requested changes.
+	ReviewChangesRequested ReviewStatus = "changes_requested"
+)
+
+// forgeForHost returns the forge that is assumed for the given host if none
+// is configured.
+func forgeForHost(host string) string {
+	switch {
+	case strings.Contains(host, "gitlab"):
+		return "gitlab"
+	case strings.Contains(host, "gitea"), strings.Contains(host, "forgejo"), host == "codeberg.org":
+		return "gitea"
+	default:
+		return "github"
+	}
+}
+
+// newForge returns the configured forge.
+func newForge(c *Context) (Forge, error) {
+	switch c.config.Forge {
+	case "github":
+		client, err := newGithubClient(c)
+		if err != nil {
+			return nil, err
+		}
+		return &githubForge{
+			client: client,
+			host:   c.config.RemoteHost,
+			owner:  c.config.RemoteOwner,
+			repo:   c.config.RemoteRepo,
+		}, nil
+	case "gitlab":
+		return newGitlabForge(c)
+	case "gitea":
+		return newGiteaForge(c), nil
+	default:
+		return nil, fmt.Errorf("unknown forge: %q", c.config.Forge)
+	}
+}
+
+// apiClient sends json requests to the rest api of a forge.
+type apiClient struct {
+	client *http.Client
+	// baseURL is the url of the api, e.g. https://gitlab.com/api/v4.
+	baseURL string
+	// header is added to every request, e.g. for authentication.
+	header http.Header
+}
+
+// do sends a request with the json encoding of in as its body to the api and
+// decodes the json response into out, unless they are nil.
+func (a *apiClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	for key, values := range a.header {
+		req.Header[key] = values
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, res.Status, bytes.TrimSpace(msg))
+	} else if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
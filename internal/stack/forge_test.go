@@ -0,0 +1,63 @@
+package stack
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+)
+
+// fakeAPI is the common part of the in-memory stand-ins for forge apis. It
+// rejects requests without the expected Authorization header, serializes the
+// requests and routes them to the handlers registered with handle.
+type fakeAPI struct {
+	*httptest.Server
+	mu     sync.Mutex
+	auth   string
+	routes []fakeRoute
+	// requests counts the handled requests by method and pattern.
+	requests map[string]int
+}
+
+type fakeRoute struct {
+	method  string
+	pattern *regexp.Regexp
+	fn      func(r *http.Request, args []string) interface{}
+}
+
+func newFakeAPI(auth string) *fakeAPI {
+	f := &fakeAPI{auth: auth, requests: map[string]int{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// handle registers fn for the requests with the given method whose escaped
+// path matches pattern. fn is called with the submatches of pattern and
+// returns the value to reply with as json, or nil to reply 404.
+func (f *fakeAPI) handle(method, pattern string, fn func(r *http.Request, args []string) interface{}) {
+	f.routes = append(f.routes, fakeRoute{method: method, pattern: regexp.MustCompile(pattern), fn: fn})
+}
+
+func (f *fakeAPI) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != f.auth {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	for _, route := range f.routes {
+		m := route.pattern.FindStringSubmatch(r.URL.EscapedPath())
+		if route.method != r.Method || m == nil {
+			continue
+		}
+		f.requests[route.method+" "+route.pattern.String()]++
+		if out := route.fn(r, m[1:]); out != nil {
+			_ = json.NewEncoder(w).Encode(out)
+			return
+		}
+		break
+	}
+	http.NotFound(w, r)
+}
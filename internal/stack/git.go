@@ -1,78 +1,162 @@
 package stack
 
 import (
-	"errors"
+	"bufio"
 	"fmt"
+	"io"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
-func GitLog(env CmdEnv, args ...string) ([]*GitCommit, error) {
-	sep, err := randomSeparator()
-	if err != nil {
-		return nil, err
-	}
-	cmd := append([]string{"git", "log", "--pretty=%H" + sep + "%B" + sep}, args...)
-	out, err := env.Run(cmd...)
-	if err != nil {
-		return nil, err
-	}
-	out = strings.TrimSpace(out)
-	if out == "" {
-		return nil, nil
-	}
-	parts := strings.Split(out, sep)
+// gitLogFields are the placeholders of the fields of a commit read by GitLog,
+// in the order of the GitCommit fields they are parsed into, followed by the
+// message.
+var gitLogFields = []string{"%H", "%T", "%P", "%an", "%ae", "%aI", "%cn", "%ce", "%cI", "%B"}
+
+// GitLog returns the commits listed by git log for the given args. The UID of
+// each commit is parsed from the given trailers, see ParseCommitUID.
+func GitLog(env CmdEnv, uidTrailers []string, args ...string) ([]*GitCommit, error) {
 	var commits []*GitCommit
-	for i := 0; i < len(parts)-1; i += 2 {
-		hash := strings.TrimSpace(parts[i])
-		msg := strings.TrimSpace(parts[i+1])
-		uid, err := ParseCommitUID(msg)
-		if err != nil {
-			return nil, fmt.Errorf("%s: %w", hash, err)
+	err := gitLogEach(env, uidTrailers, func(commit *GitCommit) error {
+		commits = append(commits, commit)
+		return nil
+	}, args...)
+	return commits, err
+}
+
+// gitLogEach calls fn for every commit listed by git log for the given args,
+// while git log is running. Fields and commits are delimited by NUL bytes,
+// which git doesn't allow in commit messages, so any message parses.
+func gitLogEach(env CmdEnv, uidTrailers []string, fn func(*GitCommit) error, args ...string) error {
+	format := strings.Join(gitLogFields, "%x00")
+	cmd := append([]string{"git", "log", "-z", "--pretty=format:" + format}, args...)
+	return env.Stream(func(r io.Reader) error {
+		br := bufio.NewReader(r)
+		fields := make([]string, 0, len(gitLogFields))
+		for {
+			field, err := br.ReadString(0)
+			eof := err == io.EOF
+			if eof {
+				// the last commit is not terminated
+				if len(fields) == 0 && field == "" {
+					return nil
+				} else if len(fields) != len(gitLogFields)-1 {
+					return fmt.Errorf("git log: truncated commit: %q", strings.Join(append(fields, field), "\x00"))
+				}
+			} else if err != nil {
+				return err
+			} else {
+				field = strings.TrimSuffix(field, "\x00")
+			}
+			fields = append(fields, field)
+			if len(fields) < len(gitLogFields) {
+				continue
+			}
+
+			commit, err := parseGitCommit(fields, uidTrailers)
+			if err != nil {
+				return err
+			} else if err := fn(commit); err != nil {
+				return err
+			} else if eof {
+				return nil
+			}
+			fields = fields[:0]
 		}
+	}, cmd...)
+}
 
-		commits = append(commits, &GitCommit{
-			Hash:    hash,
-			UID:     uid,
-			Message: msg,
-		})
+// parseGitCommit parses the fields of a commit listed by GitLog.
+func parseGitCommit(fields []string, uidTrailers []string) (*GitCommit, error) {
+	commit := &GitCommit{
+		Hash:      strings.TrimSpace(fields[0]),
+		Tree:      fields[1],
+		Parents:   strings.Fields(fields[2]),
+		Author:    GitIdentity{Name: fields[3], Email: fields[4]},
+		Committer: GitIdentity{Name: fields[6], Email: fields[7]},
+		Message:   strings.TrimSpace(fields[9]),
+	}
+	var err error
+	if commit.AuthorDate, err = time.Parse(time.RFC3339, fields[5]); err != nil {
+		return nil, fmt.Errorf("%s: bad author date: %w", commit.Hash, err)
+	}
+	if commit.CommitDate, err = time.Parse(time.RFC3339, fields[8]); err != nil {
+		return nil, fmt.Errorf("%s: bad commit date: %w", commit.Hash, err)
 	}
-	return commits, nil
+	if commit.UID, err = ParseCommitUID(commit.Message, uidTrailers...); err != nil {
+		return nil, fmt.Errorf("%s: %w", commit.Hash, err)
+	}
+	return commit, nil
 }
 
 type GitCommit struct {
 	// Hash is the git commit hash.
 	Hash string
+	// Tree is the hash of the tree of the commit.
+	Tree string
+	// Parents are the hashes of the parent commits, more than one for merges.
+	Parents []string
+	// Author is who wrote the change, AuthorDate when.
+	Author     GitIdentity
+	AuthorDate time.Time
+	// Committer is who created the commit, CommitDate when.
+	Committer  GitIdentity
+	CommitDate time.Time
 	// UID is the value of the Commit-UID trailer.
 	UID string
 	// Message string
 	Message string
 }
 
+// GitIdentity is the name and email of a commit author or committer.
+type GitIdentity struct {
+	Name  string
+	Email string
+}
+
+// IsMerge returns true if the commit has more than one parent.
+func (g GitCommit) IsMerge() bool {
+	return len(g.Parents) > 1
+}
+
+// branchPrefix is the prefix of the remote branches gh-stack pushes commits to.
+const branchPrefix = "gh-stack-commit-"
+
 func (g GitCommit) Branch() string {
 	if g.UID == "" {
 		return ""
 	}
-	return "gh-stack-commit-" + g.UID
+	return branchPrefix + g.UID
 }
 
 func (g GitCommit) Oneline() string {
 	return strings.Split(g.Message, "\n")[0]
 }
 
-func ParseCommitUID(input string) (string, error) {
-	commitPattern := regexp.MustCompile(`(?m)^Commit-UID:\s*(.*)$`)
-	matches := commitPattern.FindAllStringSubmatch(input, -1)
-
-	if matches == nil {
-		return "", nil
+// ParseCommitUID returns the value of the first of the given trailers that is
+// present in the commit message, or "" if none of them is. The keys default
+// to "Commit-UID". A trailer that is present more than once is an error.
+func ParseCommitUID(input string, keys ...string) (string, error) {
+	if len(keys) == 0 {
+		keys = []string{"Commit-UID"}
 	}
+	for _, key := range keys {
+		commitPattern := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(key) + `:\s*(.*)$`)
+		matches := commitPattern.FindAllStringSubmatch(input, -1)
 
-	if len(matches) > 1 {
-		return "", errors.New("multiple Commit-UID trailers")
-	}
+		if matches == nil {
+			continue
+		}
+
+		if len(matches) > 1 {
+			return "", fmt.Errorf("multiple %s trailers", key)
+		}
 
-	return matches[0][1], nil
+		return matches[0][1], nil
+	}
+	return "", nil
 }
 
 func gitRootDir(c *Context) (string, error) {
@@ -80,7 +164,27 @@ func gitRootDir(c *Context) (string, error) {
 	return strings.TrimSpace(s), err
 }
 
+// gitCommonDir returns the absolute path of the .git directory shared by all
+// worktrees of the repository.
+func gitCommonDir(c *Context) (string, error) {
+	out, err := c.cmd.Run("git", "rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	dir := strings.TrimSpace(out)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.cmd.Dir, dir)
+	}
+	return dir, nil
+}
+
 func gitFetch(c *Context) error {
-	_, err := c.cmd.Run("git", "fetch", c.config.RemoteName)
+	if _, err := c.cmd.RunLogged("git", "fetch", "--progress", c.config.RemoteName); err != nil {
+		return err
+	}
+	if c.config.PushRemoteName == c.config.RemoteName {
+		return nil
+	}
+	_, err := c.cmd.RunLogged("git", "fetch", "--progress", c.config.PushRemoteName)
 	return err
 }
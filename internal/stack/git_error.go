@@ -0,0 +1,116 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// GitError is returned by CmdEnv when a git command exits with a non-zero
+// status. The classifiers below tell common failures apart, so callers don't
+// have to treat every failure alike.
+type GitError struct {
+	// Args are the arguments of the command, starting with "git".
+	Args []string
+	// ExitCode is the exit status of the command.
+	ExitCode int
+	// Stderr is the standard error output of the command.
+	Stderr string
+	// Err is the underlying *exec.ExitError.
+	Err error
+}
+
+func (e *GitError) Error() string {
+	cmd := strings.Join(e.Args, " ")
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		return fmt.Sprintf("%s: %s: %s", cmd, stderr, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", cmd, e.Err)
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// newCmdError returns a *GitError if err is the exit error of a git command,
+// or err wrapped with the command and its output otherwise.
+func newCmdError(command []string, output, stderr string, err error) error {
+	var exitErr *exec.ExitError
+	if command[0] == "git" && errors.As(err, &exitErr) {
+		return &GitError{Args: command, ExitCode: exitErr.ExitCode(), Stderr: stderr, Err: err}
+	}
+	cmdS := strings.Join(command, " ")
+	if output == "" {
+		return fmt.Errorf("%s: %w", cmdS, err)
+	}
+	return fmt.Errorf("%s: %s: %w", cmdS, output, err)
+}
+
+// IsUnknownRevision returns true if err is a git error caused by a revision,
+// ref or upstream that doesn't exist.
+func IsUnknownRevision(err error) bool {
+	return gitStderrContains(err,
+		"unknown revision",
+		"bad revision",
+		"ambiguous argument",
+		"not a valid object name",
+		"not a valid ref",
+		"is not a symbolic ref",
+		"no upstream configured",
+		"no such branch",
+		"does not point to a branch",
+		"couldn't find remote ref",
+	)
+}
+
+// IsNonFastForward returns true if err is a git error caused by a push or
+// fetch that was rejected because it would lose commits.
+func IsNonFastForward(err error) bool {
+	return gitStderrContains(err,
+		"non-fast-forward",
+		"fetch first",
+		"stale info",
+		"[rejected]",
+	)
+}
+
+// IsLockContention returns true if err is a git error caused by a lock file
+// held by another git process.
+func IsLockContention(err error) bool {
+	return gitStderrContains(err,
+		".lock': File exists",
+		"cannot lock ref",
+		"Another git process seems to be running",
+	)
+}
+
+// IsAuthFailure returns true if err is a git error caused by missing or
+// rejected credentials for a remote.
+func IsAuthFailure(err error) bool {
+	return gitStderrContains(err,
+		"Authentication failed",
+		"could not read Username",
+		"could not read Password",
+		"terminal prompts disabled",
+		"Permission denied (publickey",
+		"remote: Permission to",
+		"The requested URL returned error: 401",
+		"The requested URL returned error: 403",
+	)
+}
+
+// gitStderrContains returns true if err is a *GitError whose stderr contains
+// any of the given substrings.
+func gitStderrContains(err error, substrs ...string) bool {
+	var gitErr *GitError
+	if !errors.As(err, &gitErr) {
+		return false
+	}
+	for _, s := range substrs {
+		if strings.Contains(gitErr.Stderr, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitError(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+
+	cmds := [][]string{{"git", "init", "--bare", "remote.git"}, {"git", "init", "local"}}
+	require.NoError(t, env.RunMulti(cmds...))
+	local := env
+	local.Dir = filepath.Join(env.Dir, "local")
+	cmds = createCommitCommands("A", "")
+	cmds = append(cmds, []string{"git", "push", "../remote.git", "HEAD:refs/heads/main"})
+	require.NoError(t, local.RunMulti(cmds...))
+
+	t.Run("unknown revision", func(t *testing.T) {
+		_, err := local.Run("git", "rev-parse", "--verify", "does-not-exist^{commit}")
+		var gitErr *GitError
+		require.ErrorAs(t, err, &gitErr)
+		require.Equal(t, []string{"git", "rev-parse", "--verify", "does-not-exist^{commit}"}, gitErr.Args)
+		require.Equal(t, 128, gitErr.ExitCode)
+		require.Contains(t, gitErr.Stderr, "Needed a single revision")
+		require.Equal(t, "git rev-parse --verify does-not-exist^{commit}: fatal: Needed a single revision: exit status 128", err.Error())
+
+		_, err = local.Run("git", "log", "does-not-exist")
+		require.True(t, IsUnknownRevision(err))
+		require.False(t, IsLockContention(err))
+		_, err = local.Run("git", "rev-parse", "HEAD@{upstream}")
+		require.True(t, IsUnknownRevision(err))
+	})
+
+	t.Run("non-fast-forward", func(t *testing.T) {
+		require.NoError(t, local.RunMulti([]string{"git", "commit", "--amend", "-m", "A2"}))
+		_, err := local.Run("git", "push", "../remote.git", "HEAD:refs/heads/main")
+		require.True(t, IsNonFastForward(err))
+		require.False(t, IsUnknownRevision(err))
+	})
+
+	t.Run("lock contention", func(t *testing.T) {
+		lock := filepath.Join(local.Dir, ".git", "index.lock")
+		require.NoError(t, os.WriteFile(lock, nil, 0644))
+		t.Cleanup(func() { require.NoError(t, os.Remove(lock)) })
+		_, err := local.Run("git", "add", "A")
+		require.True(t, IsLockContention(err))
+	})
+
+	t.Run("auth failure", func(t *testing.T) {
+		err := fmt.Errorf("sync: %w", &GitError{Args: []string{"git", "push"}, ExitCode: 128, Stderr: "fatal: Authentication failed for 'https://example.com/'"})
+		require.True(t, IsAuthFailure(err))
+		require.False(t, IsNonFastForward(err))
+	})
+
+	t.Run("other commands", func(t *testing.T) {
+		_, err := local.Run("false")
+		var gitErr *GitError
+		require.False(t, errors.As(err, &gitErr))
+		require.EqualError(t, err, "false: exit status 1")
+	})
+}
@@ -2,13 +2,18 @@ package stack
 
 import (
 	"errors"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseCommitUID(t *testing.T) {
 	testCases := []struct {
 		name          string
 		input         string
+		keys          []string
 		expectedUID   string
 		expectedError error
 	}{
@@ -43,11 +48,58 @@ This is a sample text.`,
 			expectedUID:   "abc-123",
 			expectedError: nil,
 		},
+		{
+			name: "Change-Id not recognized by default",
+			input: `This is a sample text.
+
+Change-Id: I123abc`,
+			expectedUID:   "",
+			expectedError: nil,
+		},
+		{
+			name: "Change-Id",
+			input: `This is a sample text.
+
+Change-Id: I123abc`,
+			keys:          []string{"Commit-UID", "Change-Id"},
+			expectedUID:   "I123abc",
+			expectedError: nil,
+		},
+		{
+			name: "Precedence",
+			input: `This is a sample text.
+
+Change-Id: I123abc
+Commit-UID: 456def`,
+			keys:          []string{"Commit-UID", "Change-Id"},
+			expectedUID:   "456def",
+			expectedError: nil,
+		},
+		{
+			name: "Reversed precedence",
+			input: `This is a sample text.
+
+Change-Id: I123abc
+Commit-UID: 456def`,
+			keys:          []string{"Change-Id", "Commit-UID"},
+			expectedUID:   "I123abc",
+			expectedError: nil,
+		},
+		{
+			name: "Multiple Change-Id lines",
+			input: `This is a sample text.
+
+Change-Id: I123abc
+Change-Id: I456def`,
+			keys:          []string{"Change-Id"},
+			expectedUID:   "",
+			expectedError: errors.New("multiple Change-Id trailers"),
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			commitUID, err := ParseCommitUID(tc.input)
+			commitUID, err := ParseCommitUID(tc.input, tc.keys...)
 
 			if commitUID != tc.expectedUID || (err != nil && err.Error() != tc.expectedError.Error() || (err == nil && tc.expectedError != nil)) {
 				t.Errorf("Expected UID: '%s', error: '%v', but got UID: '%s', error: '%v'",
@@ -56,3 +108,72 @@ This is a sample text.`,
 		})
 	}
 }
+
+func TestGitLog(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+
+	cmds := [][]string{{"git", "init"}}
+	cmds = append(cmds, createCommitCommands("A", "")...)
+	cmds = append(cmds, []string{"git", "checkout", "-b", "side"})
+	cmds = append(cmds, createCommitCommands("B", "uid-b")...)
+	cmds = append(cmds, []string{"git", "checkout", "-"})
+	require.NoError(t, env.RunMulti(cmds...))
+
+	env.Env = append(env.Env,
+		"GIT_AUTHOR_NAME=Ada", "GIT_AUTHOR_EMAIL=ada@example.com", "GIT_AUTHOR_DATE=2023-05-01T10:00:00+02:00",
+		"GIT_COMMITTER_NAME=Bob", "GIT_COMMITTER_EMAIL=bob@example.com", "GIT_COMMITTER_DATE=2023-05-02T12:00:00Z",
+	)
+	require.NoError(t, env.RunMulti(createCommitCommands("C", "")...))
+	_, err := env.Run("git", "merge", "--no-ff", "-m", "Merge side", "side")
+	require.NoError(t, err)
+
+	commits, err := GitLog(env, nil, "--first-parent", "HEAD")
+	require.NoError(t, err)
+	require.Len(t, commits, 3)
+
+	merge, c := commits[0], commits[1]
+	require.True(t, merge.IsMerge())
+	require.Len(t, merge.Parents, 2)
+	require.Equal(t, c.Hash, merge.Parents[0])
+
+	require.False(t, c.IsMerge())
+	require.Equal(t, []string{commits[2].Hash}, c.Parents)
+	require.Equal(t, "C\n\nThis is commit: C", c.Message)
+	require.Equal(t, GitIdentity{Name: "Ada", Email: "ada@example.com"}, c.Author)
+	require.Equal(t, GitIdentity{Name: "Bob", Email: "bob@example.com"}, c.Committer)
+	require.Equal(t, "2023-05-01T10:00:00+02:00", c.AuthorDate.Format(time.RFC3339))
+	require.Equal(t, "2023-05-02T12:00:00Z", c.CommitDate.Format(time.RFC3339))
+	tree, err := env.Run("git", "rev-parse", c.Hash+"^{tree}")
+	require.NoError(t, err)
+	require.Equal(t, strings.TrimSpace(tree), c.Tree)
+
+	side, err := GitLog(env, nil, "-1", "side")
+	require.NoError(t, err)
+	require.Equal(t, "uid-b", side[0].UID)
+
+	t.Run("odd messages", func(t *testing.T) {
+		msg := "D |0123456789abcdef| %x00\n\n\tindented\r\n|\n\nCommit-UID: uid-d"
+		_, err := env.Run("git", "commit", "--allow-empty", "--cleanup=verbatim", "-m", msg)
+		require.NoError(t, err)
+		commits, err := GitLog(env, nil, "-1")
+		require.NoError(t, err)
+		require.Len(t, commits, 1)
+		require.Equal(t, msg, commits[0].Message)
+		require.Equal(t, "uid-d", commits[0].UID)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := GitLog(env, nil, "does-not-exist")
+		require.ErrorContains(t, err, "unknown revision")
+
+		var n int
+		stop := errors.New("stop")
+		err = gitLogEach(env, nil, func(*GitCommit) error {
+			n++
+			return stop
+		})
+		require.ErrorIs(t, err, stop)
+		require.Equal(t, 1, n)
+	})
+}
@@ -0,0 +1,190 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// giteaForge implements Forge for gitea and its forks, such as forgejo, using
+// the v1 api.
+type giteaForge struct {
+	apiClient
+	// webURL is the url of the web interface, e.g. https://codeberg.org.
+	webURL string
+	owner  string
+	repo   string
+}
+
+func newGiteaForge(c *Context) *giteaForge {
+	webURL := "https://" + c.config.RemoteHost
+	return &giteaForge{
+		apiClient: apiClient{
+			client:  newForgeHTTPClient(c, nil),
+			baseURL: webURL + "/api/v1",
+			header:  http.Header{"Authorization": {"token " + c.config.Token}},
+		},
+		webURL: webURL,
+		owner:  c.config.RemoteOwner,
+		repo:   c.config.RemoteRepo,
+	}
+}
+
+// giteaPullRequest is the subset of the pull request api object used by
+// gh-stack.
+type giteaPullRequest struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url"`
+	Head    struct {
+		Ref  string `json:"ref"`
+		SHA  string `json:"sha"`
+		Repo struct {
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+		} `json:"repo"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+// giteaPageSize is the number of pull requests requested per page.
+const giteaPageSize = 50
+
+func (f *giteaForge) FindPullRequest(ctx context.Context, head string) (*PullRequest, error) {
+	owner, branch, ok := strings.Cut(head, ":")
+	if !ok {
+		owner, branch = f.owner, head
+	}
+	// the api has no filter for the head branch, so all pages are searched.
+	// The server caps the page size at its MAX_RESPONSE_ITEMS setting, so
+	// only an empty page marks the end.
+	for page := 1; ; page++ {
+		var prs []giteaPullRequest
+		path := fmt.Sprintf("%s/pulls?state=open&limit=%d&page=%d", f.repoPath(), giteaPageSize, page)
+		if err := f.do(ctx, "GET", path, nil, &prs); err != nil {
+			return nil, err
+		}
+		for _, pr := range prs {
+			if pr.Head.Ref == branch && strings.EqualFold(pr.Head.Repo.Owner.Login, owner) {
+				return f.fromGitea(head, &pr), nil
+			}
+		}
+		if len(prs) == 0 {
+			return nil, nil
+		}
+	}
+}
+
+func (f *giteaForge) CreatePullRequest(ctx context.Context, pr *PullRequest) error {
+	in := map[string]string{
+		"head":  pr.Head,
+		"base":  pr.Base,
+		"title": pr.Title,
+		"body":  pr.Body,
+	}
+	var created giteaPullRequest
+	if err := f.do(ctx, "POST", f.repoPath()+"/pulls", in, &created); err != nil {
+		return err
+	}
+	pr.Number = created.Number
+	pr.URL = created.HTMLURL
+	return nil
+}
+
+func (f *giteaForge) UpdatePullRequest(ctx context.Context, pr *PullRequest) error {
+	in := map[string]string{
+		"base":  pr.Base,
+		"title": pr.Title,
+		"body":  pr.Body,
+	}
+	return f.do(ctx, "PATCH", fmt.Sprintf("%s/pulls/%d", f.repoPath(), pr.Number), in, nil)
+}
+
+func (f *giteaForge) LoadStatus(ctx context.Context, pr *PullRequest) error {
+	var combined struct {
+		State      string `json:"state"`
+		TotalCount int    `json:"total_count"`
+	}
+	if err := f.do(ctx, "GET", f.repoPath()+"/commits/"+pr.HeadSHA+"/status", nil, &combined); err != nil {
+		return err
+	}
+	pr.CI = CINone
+	if combined.TotalCount > 0 {
+		pr.CI = giteaCIStatus(combined.State)
+	}
+
+	var reviews []struct {
+		State     string `json:"state"`
+		Dismissed bool   `json:"dismissed"`
+		Stale     bool   `json:"stale"`
+		User      struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}
+	if err := f.do(ctx, "GET", fmt.Sprintf("%s/pulls/%d/reviews", f.repoPath(), pr.Number), nil, &reviews); err != nil {
+		return err
+	}
+	// the latest approval or change request of each reviewer counts
+	latest := map[string]string{}
+	for _, review := range reviews {
+		if review.Dismissed || review.Stale {
+			continue
+		} else if review.State == "APPROVED" || review.State == "REQUEST_CHANGES" {
+			latest[review.User.Login] = review.State
+		}
+	}
+	pr.Review = ReviewPending
+	for _, state := range latest {
+		if state == "REQUEST_CHANGES" {
+			pr.Review = ReviewChangesRequested
+			break
+		}
+		pr.Review = ReviewApproved
+	}
+	return nil
+}
+
+// giteaCIStatus maps a combined commit status state to a CIStatus.
+func giteaCIStatus(state string) CIStatus {
+	switch state {
+	case "success", "warning":
+		return CISuccess
+	case "pending":
+		return CIPending
+	default:
+		return CIFailure
+	}
+}
+
+func (f *giteaForge) DefaultBranch(ctx context.Context) (string, error) {
+	var repo struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	err := f.do(ctx, "GET", f.repoPath(), nil, &repo)
+	return repo.DefaultBranch, err
+}
+
+func (f *giteaForge) CommitURL(owner, repo, hash string) string {
+	return f.webURL + "/" + owner + "/" + repo + "/commit/" + hash
+}
+
+func (f *giteaForge) fromGitea(head string, pr *giteaPullRequest) *PullRequest {
+	return &PullRequest{
+		Number:  pr.Number,
+		Title:   pr.Title,
+		Body:    pr.Body,
+		Head:    head,
+		HeadSHA: pr.Head.SHA,
+		Base:    pr.Base.Ref,
+		URL:     pr.HTMLURL,
+	}
+}
+
+func (f *giteaForge) repoPath() string {
+	return "/repos/" + f.owner + "/" + f.repo
+}
@@ -0,0 +1,165 @@
+package stack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGiteaForge(t *testing.T) {
+	server := newFakeGitea()
+	defer server.Close()
+
+	f := &giteaForge{
+		apiClient: apiClient{
+			client:  server.Client(),
+			baseURL: server.URL + "/api/v1",
+			header:  http.Header{"Authorization": {"token secret"}},
+		},
+		webURL: "https://codeberg.org",
+		owner:  "acme",
+		repo:   "widgets",
+	}
+	ctx := context.Background()
+
+	branch, err := f.DefaultBranch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "main", branch)
+
+	// fill more than one page with unrelated pull requests, even at the page
+	// size requested by the client
+	for i := 0; i < giteaPageSize; i++ {
+		pr := &PullRequest{Title: "other", Head: fmt.Sprintf("other-%d", i), Base: "main"}
+		require.NoError(t, f.CreatePullRequest(ctx, pr))
+	}
+
+	pr, err := f.FindPullRequest(ctx, "gh-stack-commit-a")
+	require.NoError(t, err)
+	require.Nil(t, pr)
+
+	a := &PullRequest{Title: "A", Body: "body", Head: "gh-stack-commit-a", Base: "main"}
+	require.NoError(t, f.CreatePullRequest(ctx, a))
+	require.Equal(t, giteaPageSize+1, a.Number)
+	fork := &PullRequest{Title: "A", Head: "me:gh-stack-commit-a", Base: "main"}
+	require.NoError(t, f.CreatePullRequest(ctx, fork))
+
+	pr, err = f.FindPullRequest(ctx, "gh-stack-commit-a")
+	require.NoError(t, err)
+	require.Equal(t, &PullRequest{
+		Number:  a.Number,
+		Title:   "A",
+		Body:    "body",
+		Head:    "gh-stack-commit-a",
+		HeadSHA: "sha-" + strconv.Itoa(a.Number),
+		Base:    "main",
+		URL:     a.URL,
+	}, pr)
+	pr, err = f.FindPullRequest(ctx, "me:gh-stack-commit-a")
+	require.NoError(t, err)
+	require.Equal(t, fork.Number, pr.Number)
+
+	a.Base, a.Title = "develop", "A2"
+	require.NoError(t, f.UpdatePullRequest(ctx, a))
+	pr, err = f.FindPullRequest(ctx, "gh-stack-commit-a")
+	require.NoError(t, err)
+	require.Equal(t, "develop", pr.Base)
+	require.Equal(t, "A2", pr.Title)
+
+	require.NoError(t, f.LoadStatus(ctx, pr))
+	require.Equal(t, CINone, pr.CI)
+	require.Equal(t, ReviewPending, pr.Review)
+
+	server.status = "pending"
+	server.reviews = []string{"alice:APPROVED", "bob:REQUEST_CHANGES", "bob:APPROVED"}
+	require.NoError(t, f.LoadStatus(ctx, pr))
+	require.Equal(t, CIPending, pr.CI)
+	require.Equal(t, ReviewApproved, pr.Review)
+
+	server.status = "failure"
+	server.reviews = []string{"alice:APPROVED", "bob:REQUEST_CHANGES"}
+	require.NoError(t, f.LoadStatus(ctx, pr))
+	require.Equal(t, CIFailure, pr.CI)
+	require.Equal(t, ReviewChangesRequested, pr.Review)
+
+	require.Equal(t, "https://codeberg.org/me/widgets/commit/abc", f.CommitURL("me", "widgets", "abc"))
+}
+
+// fakeGiteaMaxResponseItems is the MAX_RESPONSE_ITEMS setting of fakeGitea,
+// which caps the page size below giteaPageSize.
+const fakeGiteaMaxResponseItems = giteaPageSize / 2
+
+// fakeGitea is a minimal in-memory stand-in for the gitea v1 api of the
+// acme/widgets repository.
+type fakeGitea struct {
+	*fakeAPI
+	prs     []*giteaPullRequest
+	status  string
+	reviews []string
+}
+
+func newFakeGitea() *fakeGitea {
+	f := &fakeGitea{fakeAPI: newFakeAPI("token secret")}
+	const repo = `^/api/v1/repos/acme/widgets`
+	f.handle("GET", repo+`$`, func(_ *http.Request, _ []string) interface{} {
+		return map[string]interface{}{"default_branch": "main"}
+	})
+	f.handle("GET", repo+`/pulls$`, func(r *http.Request, _ []string) interface{} {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit > fakeGiteaMaxResponseItems {
+			limit = fakeGiteaMaxResponseItems
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		start, end := (page-1)*limit, page*limit
+		if start > len(f.prs) {
+			start = len(f.prs)
+		}
+		if end > len(f.prs) {
+			end = len(f.prs)
+		}
+		return f.prs[start:end]
+	})
+	f.handle("POST", repo+`/pulls$`, func(r *http.Request, _ []string) interface{} {
+		var in struct{ Head, Base, Title, Body string }
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		pr := &giteaPullRequest{Number: len(f.prs) + 1, Title: in.Title, Body: in.Body}
+		pr.HTMLURL = "https://codeberg.org/acme/widgets/pulls/" + strconv.Itoa(pr.Number)
+		pr.Head.Repo.Owner.Login, pr.Head.Ref = "acme", in.Head
+		if owner, branch, ok := strings.Cut(in.Head, ":"); ok {
+			pr.Head.Repo.Owner.Login, pr.Head.Ref = owner, branch
+		}
+		pr.Head.SHA = "sha-" + strconv.Itoa(pr.Number)
+		pr.Base.Ref = in.Base
+		f.prs = append(f.prs, pr)
+		return pr
+	})
+	f.handle("PATCH", repo+`/pulls/(\d+)$`, func(r *http.Request, args []string) interface{} {
+		n, _ := strconv.Atoi(args[0])
+		var in struct{ Base, Title, Body string }
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		pr := f.prs[n-1]
+		pr.Base.Ref, pr.Title, pr.Body = in.Base, in.Title, in.Body
+		return pr
+	})
+	f.handle("GET", repo+`/pulls/\d+/reviews$`, func(_ *http.Request, _ []string) interface{} {
+		reviews := []interface{}{}
+		for _, review := range f.reviews {
+			login, state, _ := strings.Cut(review, ":")
+			reviews = append(reviews, map[string]interface{}{"state": state, "user": map[string]string{"login": login}})
+		}
+		return reviews
+	})
+	f.handle("GET", repo+`/commits/[^/]+/status$`, func(_ *http.Request, _ []string) interface{} {
+		total := 0
+		if f.status != "" {
+			total = 1
+		}
+		return map[string]interface{}{"state": f.status, "total_count": total}
+	})
+	return f
+}
@@ -0,0 +1,179 @@
+package stack
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/go-github/v52/github"
+	"golang.org/x/oauth2"
+)
+
+// newGithubClient returns a github api client for the configured remote host
+// that authenticates with the configured token.
+func newGithubClient(c *Context) (*github.Client, error) {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.config.Token})
+	httpClient := newForgeHTTPClient(c, &oauth2.Transport{Source: ts})
+	if c.config.RemoteHost == "github.com" {
+		return github.NewClient(httpClient), nil
+	}
+	baseURL := "https://" + c.config.RemoteHost + "/"
+	return github.NewEnterpriseClient(baseURL, baseURL, httpClient)
+}
+
+// githubForge implements Forge for github.com and github enterprise.
+type githubForge struct {
+	client *github.Client
+	host   string
+	owner  string
+	repo   string
+}
+
+func (f *githubForge) FindPullRequest(ctx context.Context, head string) (*PullRequest, error) {
+	if !strings.Contains(head, ":") {
+		// the api requires the head to be qualified with its owner
+		head = f.owner + ":" + head
+	}
+	opts := &github.PullRequestListOptions{State: "open", Head: head}
+	prs, _, err := f.client.PullRequests.List(ctx, f.owner, f.repo, opts)
+	if err != nil {
+		return nil, err
+	} else if len(prs) == 0 {
+		return nil, nil
+	}
+	return f.fromGithub(prs[0]), nil
+}
+
+func (f *githubForge) CreatePullRequest(ctx context.Context, pr *PullRequest) error {
+	created, _, err := f.client.PullRequests.Create(ctx, f.owner, f.repo, &github.NewPullRequest{
+		Title: &pr.Title,
+		Body:  &pr.Body,
+		Head:  &pr.Head,
+		Base:  &pr.Base,
+	})
+	if err != nil {
+		return err
+	}
+	pr.Number = created.GetNumber()
+	pr.URL = created.GetHTMLURL()
+	return nil
+}
+
+func (f *githubForge) UpdatePullRequest(ctx context.Context, pr *PullRequest) error {
+	_, _, err := f.client.PullRequests.Edit(ctx, f.owner, f.repo, pr.Number, &github.PullRequest{
+		Title: &pr.Title,
+		Body:  &pr.Body,
+		Base:  &github.PullRequestBranch{Ref: &pr.Base},
+	})
+	return err
+}
+
+// githubPageSize is the number of check runs and reviews requested per page,
+// the maximum of the api.
+const githubPageSize = 100
+
+// LoadStatus loads the CI status from the combined commit status and the
+// check runs of the head, and the review status from the reviews, reading all
+// pages of them.
+func (f *githubForge) LoadStatus(ctx context.Context, pr *PullRequest) error {
+	combined, _, err := f.client.Repositories.GetCombinedStatus(ctx, f.owner, f.repo, pr.HeadSHA, nil)
+	if err != nil {
+		return err
+	}
+	var statuses []CIStatus
+	if combined.GetTotalCount() > 0 {
+		// the combined state is pending if there are no statuses at all
+		statuses = append(statuses, githubCIStatus(combined.GetState()))
+	}
+	opt := &github.ListCheckRunsOptions{ListOptions: github.ListOptions{PerPage: githubPageSize}}
+	for {
+		runs, res, err := f.client.Checks.ListCheckRunsForRef(ctx, f.owner, f.repo, pr.HeadSHA, opt)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs.CheckRuns {
+			if run.GetStatus() != "completed" {
+				statuses = append(statuses, CIPending)
+			} else {
+				statuses = append(statuses, githubCIStatus(run.GetConclusion()))
+			}
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opt.Page = res.NextPage
+	}
+	pr.CI = combineCI(statuses...)
+
+	var reviews []*github.PullRequestReview
+	listOpt := &github.ListOptions{PerPage: githubPageSize}
+	for {
+		page, res, err := f.client.PullRequests.ListReviews(ctx, f.owner, f.repo, pr.Number, listOpt)
+		if err != nil {
+			return err
+		}
+		reviews = append(reviews, page...)
+		if res.NextPage == 0 {
+			break
+		}
+		listOpt.Page = res.NextPage
+	}
+	// the latest approval or change request of each reviewer counts
+	latest := map[string]string{}
+	for _, review := range reviews {
+		if state := review.GetState(); state == "APPROVED" || state == "CHANGES_REQUESTED" || state == "DISMISSED" {
+			latest[review.GetUser().GetLogin()] = state
+		}
+	}
+	pr.Review = ReviewPending
+	for _, state := range latest {
+		if state == "CHANGES_REQUESTED" {
+			pr.Review = ReviewChangesRequested
+			break
+		} else if state == "APPROVED" {
+			pr.Review = ReviewApproved
+		}
+	}
+	return nil
+}
+
+// githubCIStatus maps a commit status state or check run conclusion to a
+// CIStatus.
+func githubCIStatus(state string) CIStatus {
+	switch state {
+	case "success", "neutral", "skipped":
+		return CISuccess
+	case "pending":
+		return CIPending
+	default:
+		return CIFailure
+	}
+}
+
+func (f *githubForge) DefaultBranch(ctx context.Context) (string, error) {
+	repo, _, err := f.client.Repositories.Get(ctx, f.owner, f.repo)
+	if err != nil {
+		return "", err
+	}
+	return repo.GetDefaultBranch(), nil
+}
+
+func (f *githubForge) CommitURL(owner, repo, hash string) string {
+	return "https://" + f.host + "/" + owner + "/" + repo + "/commit/" + hash
+}
+
+func (f *githubForge) fromGithub(pr *github.PullRequest) *PullRequest {
+	head := pr.GetHead().GetRef()
+	if pr.GetHead().GetRepo().GetOwner().GetLogin() != f.owner {
+		head = pr.GetHead().GetLabel()
+	}
+	return &PullRequest{
+		Number:  pr.GetNumber(),
+		Title:   pr.GetTitle(),
+		Body:    pr.GetBody(),
+		Head:    head,
+		HeadSHA: pr.GetHead().GetSHA(),
+		Base:    pr.GetBase().GetRef(),
+		URL:     pr.GetHTMLURL(),
+		State:   pr.GetState(),
+	}
+}
@@ -0,0 +1,168 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// githubPullRequestFields are the fields of a pull request loaded by
+// FindPullRequests, see githubGraphqlPullRequest.
+const githubPullRequestFields = `nodes {
+	number title body url state mergeable
+	headRefName headRefOid baseRefName
+	headRepositoryOwner { login }
+	reviewDecision
+	latestOpinionatedReviews(first: 100) { nodes { state } }
+	commits(last: 1) { nodes { commit { statusCheckRollup { state } } } }
+}`
+
+// githubGraphqlPullRequest is a pull request as returned by the graphql api.
+type githubGraphqlPullRequest struct {
+	Number              int
+	Title               string
+	Body                string
+	URL                 string
+	State               string
+	Mergeable           string
+	HeadRefName         string
+	HeadRefOid          string
+	BaseRefName         string
+	HeadRepositoryOwner struct{ Login string }
+	ReviewDecision      string
+	// LatestOpinionatedReviews are the latest approvals and change requests
+	// of each reviewer, used if the repository doesn't require reviews and
+	// ReviewDecision is empty.
+	LatestOpinionatedReviews struct {
+		Nodes []struct{ State string }
+	}
+	Commits struct {
+		Nodes []struct {
+			Commit struct {
+				StatusCheckRollup *struct{ State string }
+			}
+		}
+	}
+}
+
+// FindPullRequests implements batchForge with a single graphql query that
+// looks up the open pull requests of all heads, including their status.
+func (f *githubForge) FindPullRequests(ctx context.Context, heads []string) (map[string]*PullRequest, error) {
+	if len(heads) == 0 {
+		return map[string]*PullRequest{}, nil
+	}
+	var query strings.Builder
+	vars := map[string]interface{}{"owner": f.owner, "repo": f.repo}
+	query.WriteString("query($owner: String!, $repo: String!")
+	for i := range heads {
+		fmt.Fprintf(&query, ", $h%d: String!", i)
+	}
+	query.WriteString(") {\n\trepository(owner: $owner, name: $repo) {\n")
+	for i, head := range heads {
+		_, branch, ok := strings.Cut(head, ":")
+		if !ok {
+			branch = head
+		}
+		vars[fmt.Sprintf("h%d", i)] = branch
+		// pull requests from forks may use the same branch name, so a few
+		// are fetched and filtered by owner below
+		fmt.Fprintf(&query, "\t\tpr%d: pullRequests(headRefName: $h%d, states: OPEN, first: 10) { %s }\n", i, i, githubPullRequestFields)
+	}
+	query.WriteString("\t}\n}")
+
+	var res struct {
+		Data struct {
+			Repository map[string]struct {
+				Nodes []githubGraphqlPullRequest
+			}
+		}
+		Errors []struct{ Message string }
+	}
+	req, err := f.client.NewRequest("POST", f.graphqlPath(), map[string]interface{}{
+		"query":     query.String(),
+		"variables": vars,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.client.Do(ctx, req, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Errors) > 0 {
+		var msgs []string
+		for _, e := range res.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return nil, errors.New("graphql: " + strings.Join(msgs, "; "))
+	}
+
+	prs := map[string]*PullRequest{}
+	for i, head := range heads {
+		owner, _, ok := strings.Cut(head, ":")
+		if !ok {
+			owner = f.owner
+		}
+		for _, node := range res.Data.Repository[fmt.Sprintf("pr%d", i)].Nodes {
+			if strings.EqualFold(node.HeadRepositoryOwner.Login, owner) {
+				prs[head] = node.toPullRequest(head)
+				break
+			}
+		}
+	}
+	return prs, nil
+}
+
+// graphqlPath returns the path of the graphql endpoint relative to the base
+// url of the rest api, which is /api/v3/ on github enterprise and / on
+// api.github.com, while graphql is served from /api/graphql and /graphql.
+func (f *githubForge) graphqlPath() string {
+	if strings.HasSuffix(f.client.BaseURL.Path, "/api/v3/") {
+		return "../graphql"
+	}
+	return "graphql"
+}
+
+func (p *githubGraphqlPullRequest) toPullRequest(head string) *PullRequest {
+	pr := &PullRequest{
+		Number:    p.Number,
+		Title:     p.Title,
+		Body:      p.Body,
+		Head:      head,
+		HeadSHA:   p.HeadRefOid,
+		Base:      p.BaseRefName,
+		URL:       p.URL,
+		State:     strings.ToLower(p.State),
+		Mergeable: strings.ToLower(p.Mergeable),
+		CI:        CINone,
+		Review:    ReviewPending,
+	}
+	if len(p.Commits.Nodes) > 0 {
+		if rollup := p.Commits.Nodes[0].Commit.StatusCheckRollup; rollup != nil {
+			switch rollup.State {
+			case "SUCCESS":
+				pr.CI = CISuccess
+			case "PENDING", "EXPECTED":
+				pr.CI = CIPending
+			default:
+				pr.CI = CIFailure
+			}
+		}
+	}
+	switch p.ReviewDecision {
+	case "APPROVED":
+		pr.Review = ReviewApproved
+	case "CHANGES_REQUESTED":
+		pr.Review = ReviewChangesRequested
+	case "":
+		for _, review := range p.LatestOpinionatedReviews.Nodes {
+			if review.State == "CHANGES_REQUESTED" {
+				pr.Review = ReviewChangesRequested
+				break
+			} else if review.State == "APPROVED" {
+				pr.Review = ReviewApproved
+			}
+		}
+	}
+	return pr
+}
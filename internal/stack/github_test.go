@@ -0,0 +1,139 @@
+package stack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGithubForgeFindPullRequests(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/graphql", r.URL.Path)
+		var req map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {"repository": {
+			"pr0": {"nodes": [{
+				"number": 1, "title": "A", "url": "https://example.com/pull/1",
+				"state": "OPEN", "mergeable": "MERGEABLE",
+				"headRefName": "gh-stack-commit-a", "headRefOid": "sha-a", "baseRefName": "main",
+				"headRepositoryOwner": {"login": "acme"},
+				"reviewDecision": "APPROVED",
+				"commits": {"nodes": [{"commit": {"statusCheckRollup": {"state": "SUCCESS"}}}]}
+			}]},
+			"pr1": {"nodes": []},
+			"pr2": {"nodes": [{
+				"number": 2, "headRefName": "gh-stack-commit-c", "headRepositoryOwner": {"login": "acme"}
+			}, {
+				"number": 3, "title": "C", "state": "OPEN", "mergeable": "CONFLICTING",
+				"headRefName": "gh-stack-commit-c", "headRefOid": "sha-c", "baseRefName": "main",
+				"headRepositoryOwner": {"login": "me"},
+				"latestOpinionatedReviews": {"nodes": [{"state": "APPROVED"}, {"state": "CHANGES_REQUESTED"}]},
+				"commits": {"nodes": [{"commit": {"statusCheckRollup": {"state": "EXPECTED"}}}]}
+			}]}
+		}}}`))
+	}))
+	defer server.Close()
+
+	client, err := github.NewEnterpriseClient(server.URL+"/", server.URL+"/", server.Client())
+	require.NoError(t, err)
+	f := &githubForge{client: client, host: "github.example.com", owner: "acme", repo: "widgets"}
+
+	prs, err := f.FindPullRequests(context.Background(), []string{"gh-stack-commit-a", "gh-stack-commit-b", "me:gh-stack-commit-c"})
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	require.Equal(t, map[string]interface{}{
+		"owner": "acme", "repo": "widgets",
+		"h0": "gh-stack-commit-a", "h1": "gh-stack-commit-b", "h2": "gh-stack-commit-c",
+	}, requests[0]["variables"])
+
+	require.Len(t, prs, 2)
+	require.Equal(t, &PullRequest{
+		Number:    1,
+		Title:     "A",
+		Head:      "gh-stack-commit-a",
+		HeadSHA:   "sha-a",
+		Base:      "main",
+		URL:       "https://example.com/pull/1",
+		State:     "open",
+		Mergeable: "mergeable",
+		CI:        CISuccess,
+		Review:    ReviewApproved,
+	}, prs["gh-stack-commit-a"])
+	c := prs["me:gh-stack-commit-c"]
+	require.Equal(t, 3, c.Number)
+	require.Equal(t, "conflicting", c.Mergeable)
+	require.Equal(t, CIPending, c.CI)
+	require.Equal(t, ReviewChangesRequested, c.Review)
+
+	prs, err = f.FindPullRequests(context.Background(), nil)
+	require.NoError(t, err)
+	require.Empty(t, prs)
+	require.Len(t, requests, 1)
+}
+
+func TestGithubForgeFindPullRequestsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/graphql", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"errors": [{"message": "Field 'latestOpinionatedReviews' doesn't exist"}]}`))
+	}))
+	defer server.Close()
+
+	client := github.NewClient(server.Client())
+	client.BaseURL.Scheme, client.BaseURL.Host = "http", server.Listener.Addr().String()
+	f := &githubForge{client: client, owner: "acme", repo: "widgets"}
+	_, err := f.FindPullRequests(context.Background(), []string{"gh-stack-commit-a"})
+	require.EqualError(t, err, "graphql: Field 'latestOpinionatedReviews' doesn't exist")
+}
+
+func TestGithubForgeLoadStatus(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		next := func() {
+			w.Header().Set("Link", `<`+server.URL+r.URL.Path+`?page=2>; rel="next"`)
+		}
+		page2 := r.URL.Query().Get("page") == "2"
+		switch r.URL.Path {
+		case "/api/v3/repos/acme/widgets/commits/sha-a/status":
+			_, _ = w.Write([]byte(`{"state": "success", "total_count": 1}`))
+		case "/api/v3/repos/acme/widgets/commits/sha-a/check-runs":
+			require.Equal(t, "100", r.URL.Query().Get("per_page"))
+			if page2 {
+				_, _ = w.Write([]byte(`{"check_runs": [{"status": "completed", "conclusion": "failure"}]}`))
+				return
+			}
+			next()
+			_, _ = w.Write([]byte(`{"check_runs": [{"status": "completed", "conclusion": "success"}]}`))
+		case "/api/v3/repos/acme/widgets/pulls/1/reviews":
+			require.Equal(t, "100", r.URL.Query().Get("per_page"))
+			if page2 {
+				_, _ = w.Write([]byte(`[{"state": "CHANGES_REQUESTED", "user": {"login": "b"}}]`))
+				return
+			}
+			next()
+			_, _ = w.Write([]byte(`[{"state": "APPROVED", "user": {"login": "a"}}]`))
+		default:
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	client, err := github.NewEnterpriseClient(server.URL+"/", server.URL+"/", server.Client())
+	require.NoError(t, err)
+	f := &githubForge{client: client, host: "github.example.com", owner: "acme", repo: "widgets"}
+
+	// the failure and the change request are on the second pages
+	pr := &PullRequest{Number: 1, HeadSHA: "sha-a"}
+	require.NoError(t, f.LoadStatus(context.Background(), pr))
+	require.Equal(t, CIFailure, pr.CI)
+	require.Equal(t, ReviewChangesRequested, pr.Review)
+}
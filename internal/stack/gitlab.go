@@ -0,0 +1,220 @@
+package stack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gitlabForge implements Forge for gitlab merge requests using the v4 api.
+// Merge requests are chained via their target_branch, the head pipeline is
+// reported as the CI status and approvals as the review status. Changes are
+// considered requested if a reviewer requested them or if discussions are
+// unresolved, see gitlabReviewStatus.
+type gitlabForge struct {
+	apiClient
+	// webURL is the url of the web interface, e.g. https://gitlab.com.
+	webURL string
+	// project is the path of the project merge requests are opened in.
+	project string
+	// pushProject is the path of the project the branches are pushed to.
+	pushProject string
+	// projectID and pushProjectID are the ids of project and pushProject,
+	// see loadProjectIDs.
+	projectID     int
+	pushProjectID int
+}
+
+func newGitlabForge(c *Context) (*gitlabForge, error) {
+	webURL := "https://" + c.config.RemoteHost
+	f := &gitlabForge{
+		apiClient: apiClient{
+			client:  newForgeHTTPClient(c, nil),
+			baseURL: webURL + "/api/v4",
+			header:  http.Header{"Authorization": {"Bearer " + c.config.Token}},
+		},
+		webURL:      webURL,
+		project:     c.config.RemoteOwner + "/" + c.config.RemoteRepo,
+		pushProject: c.config.PushOwner + "/" + c.config.PushRepo,
+	}
+	return f, f.loadProjectIDs(c.cmd.Context())
+}
+
+// loadProjectIDs looks up the ids of the projects, which most endpoints
+// need, once rather than for every request.
+func (f *gitlabForge) loadProjectIDs(ctx context.Context) error {
+	var err error
+	if f.projectID, err = f.lookupProjectID(ctx, f.project); err != nil {
+		return fmt.Errorf("failed to look up gitlab project %s: %w", f.project, err)
+	} else if f.pushProject == f.project {
+		f.pushProjectID = f.projectID
+	} else if f.pushProjectID, err = f.lookupProjectID(ctx, f.pushProject); err != nil {
+		return fmt.Errorf("failed to look up gitlab project %s: %w", f.pushProject, err)
+	}
+	return nil
+}
+
+// gitlabMergeRequest is the subset of the merge request api object used by
+// gh-stack.
+type gitlabMergeRequest struct {
+	IID             int    `json:"iid"`
+	Title           string `json:"title"`
+	Description     string `json:"description"`
+	SourceBranch    string `json:"source_branch"`
+	TargetBranch    string `json:"target_branch"`
+	SourceProjectID int    `json:"source_project_id"`
+	TargetProjectID int    `json:"target_project_id"`
+	WebURL          string `json:"web_url"`
+	SHA             string `json:"sha"`
+	HeadPipeline    *struct {
+		Status string `json:"status"`
+	} `json:"head_pipeline"`
+	DetailedMergeStatus string `json:"detailed_merge_status"`
+}
+
+func (f *gitlabForge) FindPullRequest(ctx context.Context, head string) (*PullRequest, error) {
+	branch, sourceProjectID := f.source(head)
+	query := url.Values{"state": {"opened"}, "source_branch": {branch}}
+	var mrs []gitlabMergeRequest
+	if err := f.do(ctx, "GET", f.projectPath(f.project)+"/merge_requests?"+query.Encode(), nil, &mrs); err != nil {
+		return nil, err
+	}
+	for _, mr := range mrs {
+		if mr.SourceProjectID == sourceProjectID {
+			return f.fromGitlab(head, &mr), nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *gitlabForge) CreatePullRequest(ctx context.Context, pr *PullRequest) error {
+	branch, sourceProjectID := f.source(pr.Head)
+	in := map[string]interface{}{
+		"source_branch":     branch,
+		"target_branch":     pr.Base,
+		"title":             pr.Title,
+		"description":       pr.Body,
+		"target_project_id": f.projectID,
+	}
+	// merge requests from forks are created in the source project
+	path := fmt.Sprintf("/projects/%d/merge_requests", sourceProjectID)
+	var mr gitlabMergeRequest
+	if err := f.do(ctx, "POST", path, in, &mr); err != nil {
+		return err
+	}
+	pr.Number = mr.IID
+	pr.URL = mr.WebURL
+	return nil
+}
+
+func (f *gitlabForge) UpdatePullRequest(ctx context.Context, pr *PullRequest) error {
+	in := map[string]interface{}{
+		"target_branch": pr.Base,
+		"title":         pr.Title,
+		"description":   pr.Body,
+	}
+	return f.do(ctx, "PUT", f.mergeRequestPath(pr.Number), in, nil)
+}
+
+func (f *gitlabForge) LoadStatus(ctx context.Context, pr *PullRequest) error {
+	var mr gitlabMergeRequest
+	if err := f.do(ctx, "GET", f.mergeRequestPath(pr.Number), nil, &mr); err != nil {
+		return err
+	}
+	pr.CI = CINone
+	if mr.HeadPipeline != nil {
+		pr.CI = gitlabCIStatus(mr.HeadPipeline.Status)
+	}
+
+	var approvals struct {
+		Approved   bool              `json:"approved"`
+		ApprovedBy []json.RawMessage `json:"approved_by"`
+	}
+	if err := f.do(ctx, "GET", f.mergeRequestPath(pr.Number)+"/approvals", nil, &approvals); err != nil {
+		return err
+	}
+	pr.Review = gitlabReviewStatus(mr.DetailedMergeStatus, approvals.Approved && len(approvals.ApprovedBy) > 0)
+	return nil
+}
+
+// gitlabReviewStatus maps the detailed merge status and the approval of a
+// merge request to a ReviewStatus. gitlab has no change requests before
+// version 17, so unresolved discussions count as requested changes as well.
+func gitlabReviewStatus(detailedMergeStatus string, approved bool) ReviewStatus {
+	switch {
+	case detailedMergeStatus == "requested_changes", detailedMergeStatus == "discussions_not_resolved":
+		return ReviewChangesRequested
+	case approved:
+		return ReviewApproved
+	default:
+		return ReviewPending
+	}
+}
+
+// gitlabCIStatus maps a pipeline status to a CIStatus.
+func gitlabCIStatus(status string) CIStatus {
+	switch status {
+	case "success":
+		return CISuccess
+	case "failed", "canceled":
+		return CIFailure
+	case "skipped", "":
+		return CINone
+	default:
+		// created, waiting_for_resource, preparing, pending, running, manual,
+		// scheduled
+		return CIPending
+	}
+}
+
+func (f *gitlabForge) DefaultBranch(ctx context.Context) (string, error) {
+	var project struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	err := f.do(ctx, "GET", f.projectPath(f.project), nil, &project)
+	return project.DefaultBranch, err
+}
+
+func (f *gitlabForge) CommitURL(owner, repo, hash string) string {
+	return f.webURL + "/" + owner + "/" + repo + "/-/commit/" + hash
+}
+
+// source returns the branch and the id of the project the given pull request
+// head refers to. Heads qualified with an owner refer to the push project.
+func (f *gitlabForge) source(head string) (string, int) {
+	if _, branch, ok := strings.Cut(head, ":"); ok {
+		return branch, f.pushProjectID
+	}
+	return head, f.projectID
+}
+
+func (f *gitlabForge) lookupProjectID(ctx context.Context, project string) (int, error) {
+	var p struct {
+		ID int `json:"id"`
+	}
+	err := f.do(ctx, "GET", f.projectPath(project), nil, &p)
+	return p.ID, err
+}
+
+func (f *gitlabForge) fromGitlab(head string, mr *gitlabMergeRequest) *PullRequest {
+	return &PullRequest{
+		Number:  mr.IID,
+		Title:   mr.Title,
+		Body:    mr.Description,
+		Head:    head,
+		HeadSHA: mr.SHA,
+		Base:    mr.TargetBranch,
+		URL:     mr.WebURL,
+	}
+}
+
+func (f *gitlabForge) projectPath(project string) string {
+	return "/projects/" + url.PathEscape(project)
+}
+
+func (f *gitlabForge) mergeRequestPath(iid int) string {
+	return fmt.Sprintf("%s/merge_requests/%d", f.projectPath(f.project), iid)
+}
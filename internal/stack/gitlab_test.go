@@ -0,0 +1,185 @@
+package stack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitlabForge(t *testing.T) {
+	server := newFakeGitlab(map[string]int{"acme/widgets": 1, "me/widgets": 2})
+	defer server.Close()
+
+	f := &gitlabForge{
+		apiClient: apiClient{
+			client:  server.Client(),
+			baseURL: server.URL + "/api/v4",
+			header:  http.Header{"Authorization": {"Bearer secret"}},
+		},
+		webURL:      "https://gitlab.example.com",
+		project:     "acme/widgets",
+		pushProject: "me/widgets",
+	}
+	ctx := context.Background()
+	require.NoError(t, f.loadProjectIDs(ctx))
+	require.Equal(t, 1, f.projectID)
+	require.Equal(t, 2, f.pushProjectID)
+
+	branch, err := f.DefaultBranch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "main", branch)
+	lookups := server.requests["GET "+fakeGitlabProject+"$"]
+
+	pr, err := f.FindPullRequest(ctx, "gh-stack-commit-a")
+	require.NoError(t, err)
+	require.Nil(t, pr)
+
+	a := &PullRequest{Title: "A", Body: "body", Head: "gh-stack-commit-a", Base: "main"}
+	require.NoError(t, f.CreatePullRequest(ctx, a))
+	require.Equal(t, 1, a.Number)
+	b := &PullRequest{Title: "B", Head: "gh-stack-commit-b", Base: "gh-stack-commit-a"}
+	require.NoError(t, f.CreatePullRequest(ctx, b))
+	require.Equal(t, 2, b.Number)
+	fork := &PullRequest{Title: "A", Head: "me:gh-stack-commit-a", Base: "main"}
+	require.NoError(t, f.CreatePullRequest(ctx, fork))
+	require.Equal(t, 3, fork.Number)
+
+	pr, err = f.FindPullRequest(ctx, "gh-stack-commit-b")
+	require.NoError(t, err)
+	require.Equal(t, &PullRequest{
+		Number:  2,
+		Title:   "B",
+		Head:    "gh-stack-commit-b",
+		HeadSHA: "sha-2",
+		Base:    "gh-stack-commit-a",
+		URL:     "https://gitlab.example.com/acme/widgets/-/merge_requests/2",
+	}, pr)
+	pr, err = f.FindPullRequest(ctx, "me:gh-stack-commit-a")
+	require.NoError(t, err)
+	require.Equal(t, 3, pr.Number)
+
+	b.Base = "main"
+	require.NoError(t, f.UpdatePullRequest(ctx, b))
+	pr, err = f.FindPullRequest(ctx, "gh-stack-commit-b")
+	require.NoError(t, err)
+	require.Equal(t, "main", pr.Base)
+
+	server.pipeline, server.approved = "running", false
+	require.NoError(t, f.LoadStatus(ctx, pr))
+	require.Equal(t, CIPending, pr.CI)
+	require.Equal(t, ReviewPending, pr.Review)
+	server.pipeline, server.approved = "failed", true
+	require.NoError(t, f.LoadStatus(ctx, pr))
+	require.Equal(t, CIFailure, pr.CI)
+	require.Equal(t, ReviewApproved, pr.Review)
+	server.mergeStatus = "discussions_not_resolved"
+	require.NoError(t, f.LoadStatus(ctx, pr))
+	require.Equal(t, ReviewChangesRequested, pr.Review)
+	server.mergeStatus = "requested_changes"
+	require.NoError(t, f.LoadStatus(ctx, pr))
+	require.Equal(t, ReviewChangesRequested, pr.Review)
+
+	// the project ids were looked up once
+	require.Equal(t, lookups, server.requests["GET "+fakeGitlabProject+"$"])
+
+	require.Equal(t, "https://gitlab.example.com/me/widgets/-/commit/abc", f.CommitURL("me", "widgets", "abc"))
+}
+
+// fakeGitlab is a minimal in-memory stand-in for the gitlab v4 api.
+type fakeGitlab struct {
+	*fakeAPI
+	projects    map[string]int
+	mrs         []*gitlabMergeRequest
+	pipeline    string
+	approved    bool
+	mergeStatus string
+}
+
+// fakeGitlabProject is the pattern of the project routes of fakeGitlab.
+const fakeGitlabProject = `^/api/v4/projects/([^/]+)`
+
+func newFakeGitlab(projects map[string]int) *fakeGitlab {
+	f := &fakeGitlab{fakeAPI: newFakeAPI("Bearer secret"), projects: projects}
+	f.handle("GET", fakeGitlabProject+`$`, func(_ *http.Request, args []string) interface{} {
+		id, ok := f.projectID(args[0])
+		if !ok {
+			return nil
+		}
+		return map[string]interface{}{"id": id, "default_branch": "main"}
+	})
+	f.handle("GET", fakeGitlabProject+`/merge_requests$`, func(r *http.Request, args []string) interface{} {
+		id, _ := f.projectID(args[0])
+		mrs := []*gitlabMergeRequest{}
+		for _, mr := range f.mrs {
+			if mr.TargetProjectID == id && mr.SourceBranch == r.URL.Query().Get("source_branch") {
+				mrs = append(mrs, mr)
+			}
+		}
+		return mrs
+	})
+	f.handle("POST", fakeGitlabProject+`/merge_requests$`, func(r *http.Request, args []string) interface{} {
+		var in struct {
+			gitlabMergeRequest
+			TargetProjectID int `json:"target_project_id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		mr := in.gitlabMergeRequest
+		mr.IID = len(f.mrs) + 1
+		mr.SourceProjectID, _ = f.projectID(args[0])
+		mr.TargetProjectID = in.TargetProjectID
+		mr.SHA = "sha-" + strconv.Itoa(mr.IID)
+		mr.WebURL = "https://gitlab.example.com/acme/widgets/-/merge_requests/" + strconv.Itoa(mr.IID)
+		f.mrs = append(f.mrs, &mr)
+		return mr
+	})
+	f.handle("PUT", fakeGitlabProject+`/merge_requests/(\d+)$`, func(r *http.Request, args []string) interface{} {
+		mr := f.mergeRequest(args[1])
+		if mr != nil {
+			_ = json.NewDecoder(r.Body).Decode(mr)
+		}
+		return mr
+	})
+	f.handle("GET", fakeGitlabProject+`/merge_requests/(\d+)$`, func(_ *http.Request, args []string) interface{} {
+		mr := f.mergeRequest(args[1])
+		if mr == nil {
+			return nil
+		}
+		out := *mr
+		out.HeadPipeline = &struct {
+			Status string `json:"status"`
+		}{Status: f.pipeline}
+		out.DetailedMergeStatus = f.mergeStatus
+		return out
+	})
+	f.handle("GET", fakeGitlabProject+`/merge_requests/(\d+)/approvals$`, func(_ *http.Request, _ []string) interface{} {
+		approvedBy := []interface{}{}
+		if f.approved {
+			approvedBy = append(approvedBy, map[string]interface{}{"user": map[string]interface{}{"username": "reviewer"}})
+		}
+		return map[string]interface{}{"approved": f.approved, "approved_by": approvedBy}
+	})
+	return f
+}
+
+// projectID returns the id of the project with the given escaped path or id.
+func (f *fakeGitlab) projectID(project string) (int, bool) {
+	if id, err := strconv.Atoi(project); err == nil {
+		return id, true
+	}
+	project, _ = url.PathUnescape(project)
+	id, ok := f.projects[project]
+	return id, ok
+}
+
+func (f *fakeGitlab) mergeRequest(iid string) *gitlabMergeRequest {
+	n, _ := strconv.Atoi(iid)
+	if n < 1 || n > len(f.mrs) {
+		return nil
+	}
+	return f.mrs[n-1]
+}
@@ -0,0 +1,104 @@
+package stack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hookMarker identifies hooks installed by gh-stack, so they can be replaced
+// without clobbering hooks installed by other tools.
+const hookMarker = "# installed by gh-stack"
+
+// InstallHooks installs a commit-msg hook that runs the given gh-stack
+// executable to assign a UID to every new commit. Existing hooks that were not
+// installed by gh-stack are only replaced if force is true. It returns the
+// path of the hook.
+func InstallHooks(c *Context, executable string, force bool) (string, error) {
+	if err := checkHookUIDGenerator(c); err != nil {
+		return "", err
+	}
+	out, err := c.cmd.Run("git", "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	dir := strings.TrimSpace(out)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.cmd.Dir, dir)
+	}
+	path := filepath.Join(dir, "commit-msg")
+
+	if data, err := os.ReadFile(path); err == nil && !bytes.Contains(data, []byte(hookMarker)) && !force {
+		return "", fmt.Errorf("%s already exists and was not installed by gh-stack, use --force to replace it", path)
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	script := fmt.Sprintf("#!/bin/sh\n%s: assigns commit UIDs to new commits\nexec %s commit-msg-hook \"$1\"\n", hookMarker, shellQuote(executable))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, []byte(script), 0755)
+}
+
+// AddUIDTrailer adds a trailer with a new UID to the commit message in the
+// given file, unless the message already has one of the UIDTrailers. The key
+// of the added trailer is the first of the UIDTrailers, see formatUID. Empty
+// messages as well as fixup and squash commits are left untouched, as they
+// don't end up as commits of their own.
+//
+// The seeded generator is refused, as every run of the hook is a new process
+// that would start the same sequence again and give every commit the same UID.
+func AddUIDTrailer(c *Context, msgFile string) error {
+	if err := checkHookUIDGenerator(c); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(msgFile)
+	if err != nil {
+		return err
+	}
+	msg := stripComments(string(data))
+	if strings.TrimSpace(msg) == "" || strings.HasPrefix(msg, "fixup! ") || strings.HasPrefix(msg, "squash! ") {
+		return nil
+	}
+	if uid, err := ParseCommitUID(msg, c.config.UIDTrailers...); err != nil || uid != "" {
+		return err
+	}
+
+	uid, err := c.uids.NewUID()
+	if err != nil {
+		return err
+	}
+	key := c.config.UIDTrailers[0]
+	trailer := key + ": " + formatUID(key, uid)
+	_, err = c.cmd.Run("git", "interpret-trailers", "--in-place", "--trailer", trailer, msgFile)
+	return err
+}
+
+// checkHookUIDGenerator returns an error if the UID generator can't be used by
+// the commit-msg hook.
+func checkHookUIDGenerator(c *Context) error {
+	if _, ok := c.uids.(*seededUIDGenerator); ok {
+		return errors.New("the seeded uid generator can't be used with the commit-msg hook, it would assign the same UID to every commit")
+	}
+	return nil
+}
+
+// stripComments removes the lines starting with # from a commit message.
+func stripComments(msg string) string {
+	var lines []string
+	for _, line := range strings.Split(msg, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// shellQuote quotes s for use as a single word in a posix shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,82 @@
+package stack
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+	_, err := env.Run("git", "init")
+	require.NoError(t, err)
+
+	c := newTestContext(env.Dir, Config{}.WithDefaults())
+	// the seeded generator is refused by the hook
+	c.uids = &ulidGenerator{
+		now:     func() time.Time { return time.UnixMilli(1469918176385) },
+		entropy: bytes.NewReader(make([]byte, 10)),
+	}
+
+	t.Run("InstallHooks", func(t *testing.T) {
+		path, err := InstallHooks(c, "/opt/it's/gh-stack", false)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(env.Dir, ".git", "hooks", "commit-msg"), path)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Contains(t, string(data), `exec '/opt/it'\''s/gh-stack' commit-msg-hook "$1"`)
+
+		// reinstalling replaces our own hook, but not foreign ones
+		_, err = InstallHooks(c, "gh-stack", false)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"), 0755))
+		_, err = InstallHooks(c, "gh-stack", false)
+		require.ErrorContains(t, err, "was not installed by gh-stack")
+		_, err = InstallHooks(c, "gh-stack", true)
+		require.NoError(t, err)
+	})
+
+	t.Run("AddUIDTrailer", func(t *testing.T) {
+		addUIDTrailer := func(t *testing.T, msg string) string {
+			t.Helper()
+			path := filepath.Join(env.Dir, "COMMIT_EDITMSG")
+			require.NoError(t, os.WriteFile(path, []byte(msg), 0644))
+			require.NoError(t, AddUIDTrailer(c, path))
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			return string(data)
+		}
+
+		require.Equal(t, "A\n\nBody\n\nCommit-UID: 01aryz6s410000000000000000\n", addUIDTrailer(t, "A\n\nBody\n"))
+		require.Equal(t, "B\n\nCommit-UID: 123\n", addUIDTrailer(t, "B\n\nCommit-UID: 123\n"))
+		require.Equal(t, "fixup! A\n", addUIDTrailer(t, "fixup! A\n"))
+		require.Equal(t, "\n# Please enter the commit message\n", addUIDTrailer(t, "\n# Please enter the commit message\n"))
+
+		c.config.UIDTrailers = []string{"Change-Id", "Commit-UID"}
+		require.Equal(t, "C\n\nCommit-UID: 123\n", addUIDTrailer(t, "C\n\nCommit-UID: 123\n"))
+		// gerrit only accepts an I followed by 40 hex characters
+		require.Equal(t, "D\n\nChange-Id: I"+sha1Hex("01aryz6s410000000000000001")+"\n", addUIDTrailer(t, "D\n"))
+	})
+
+	t.Run("seeded", func(t *testing.T) {
+		c := newTestContext(env.Dir, Config{}.WithDefaults())
+		c.uids = newSeededUIDGenerator(1)
+		path := filepath.Join(env.Dir, "COMMIT_EDITMSG")
+		require.NoError(t, os.WriteFile(path, []byte("E\n"), 0644))
+		require.ErrorContains(t, AddUIDTrailer(c, path), "seeded uid generator can't be used with the commit-msg hook")
+		_, err := InstallHooks(c, "gh-stack", true)
+		require.ErrorContains(t, err, "seeded uid generator can't be used with the commit-msg hook")
+	})
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,174 @@
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Operation is an entry of the journal under .git/gh-stack/journal. Commands
+// that change the local stack, remote branches or pull requests record the
+// previous state in it before changing anything, so Undo can restore it.
+type Operation struct {
+	// Name is the command that performed the operation, e.g. "sync".
+	Name string
+	// Time is when the operation started.
+	Time time.Time
+	// LocalRef is the full name of the ref LocalHead resolved to, e.g.
+	// refs/heads/main, or HEAD if it was detached.
+	LocalRef string
+	// OldLocalHash is the value of LocalRef before the operation, NewLocalHash
+	// the value it was set to, which is the same if it wasn't changed.
+	OldLocalHash string
+	NewLocalHash string
+	// PushRemoteName is the remote the branches were pushed to.
+	PushRemoteName string
+	// Branches are the remote branches that are pushed.
+	Branches []BranchUpdate
+	// Pushed is set once all Branches were pushed. An interrupted operation
+	// may have pushed some of them before.
+	Pushed bool
+	// PullRequests are the pull requests that were created or updated.
+	PullRequests []PullRequestUpdate
+	// Done is set once the operation completed. Operations that were
+	// interrupted can be resumed or undone.
+	Done bool
+
+	// file is where the operation is stored.
+	file string
+}
+
+// BranchUpdate is the change of a remote branch by an operation.
+type BranchUpdate struct {
+	Branch string
+	// Old is the previous hash of the branch, or "" if it didn't exist.
+	Old string
+	New string
+}
+
+// PullRequestUpdate is the change of a pull request by an operation.
+type PullRequestUpdate struct {
+	// Old is the previous state of the pull request, or nil if it was
+	// created by the operation.
+	Old *PullRequest
+	New *PullRequest
+}
+
+// journalDir returns the directory of the journal of the repository.
+func journalDir(c *Context) (string, error) {
+	dir, err := gitCommonDir(c)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gh-stack", "journal"), nil
+}
+
+// beginOperation adds a new operation with the given name to the journal,
+// recording the current value of LocalHead.
+func beginOperation(c *Context, name string) (*Operation, error) {
+	dir, err := journalDir(c)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := c.cmd.Run("git", "rev-parse", "--symbolic-full-name", c.config.LocalHead)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := c.cmd.Run("git", "rev-parse", "--verify", c.config.LocalHead+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	op := &Operation{
+		Name:           name,
+		Time:           now,
+		LocalRef:       strings.TrimSpace(ref),
+		OldLocalHash:   strings.TrimSpace(hash),
+		NewLocalHash:   strings.TrimSpace(hash),
+		PushRemoteName: c.config.PushRemoteName,
+		// the names sort in the order the operations were started
+		file: filepath.Join(dir, fmt.Sprintf("%020d-%s.json", now.UnixNano(), name)),
+	}
+	if op.LocalRef == "" {
+		// rev-parse prints nothing for refs that are not symbolic
+		op.LocalRef = c.config.LocalHead
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return op, op.save()
+}
+
+// save writes the operation to the journal. It is called before every change
+// an operation makes, so the journal never misses a change.
+func (op *Operation) save() error {
+	data, err := json.MarshalIndent(op, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := op.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, op.file)
+}
+
+// Progress describes how far the operation got, e.g. for reporting an
+// interrupted operation.
+func (op *Operation) Progress() string {
+	switch {
+	case op.Done:
+		return "after it completed"
+	case !op.Pushed:
+		return "before it finished pushing its branches"
+	case len(op.PullRequests) == 1:
+		return "after pushing its branches and creating or updating 1 pull request"
+	default:
+		return fmt.Sprintf("after pushing its branches and creating or updating %d pull requests", len(op.PullRequests))
+	}
+}
+
+// removeIfNoop removes the operation from the journal if it didn't change
+// anything, so undo skips it.
+func (op *Operation) removeIfNoop() error {
+	if op.OldLocalHash != op.NewLocalHash || len(op.PullRequests) > 0 {
+		return nil
+	}
+	for _, b := range op.Branches {
+		if b.Old != b.New {
+			return nil
+		}
+	}
+	return os.Remove(op.file)
+}
+
+// LastOperation returns the most recent operation of the journal, or nil if
+// the journal is empty.
+func LastOperation(c *Context) (*Operation, error) {
+	dir, err := journalDir(c)
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	} else if len(files) == 0 {
+		return nil, nil
+	}
+	sort.Strings(files)
+	file := files[len(files)-1]
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	op := &Operation{file: file}
+	if err := json.Unmarshal(data, op); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return op, nil
+}
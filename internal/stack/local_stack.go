@@ -1,11 +1,24 @@
 package stack
 
+import (
+	"context"
+	"fmt"
+)
+
 type LocalStack struct {
 	Commits []*GitCommit
 }
 
-// Load populates the local stack according to the config.
-func (l *LocalStack) Load(c *Context) (err error) {
-	l.Commits, err = GitLog(c.cmd, c.mergeBase+".."+c.config.LocalHead)
-	return err
+// Load populates the local stack according to the config. Merge commits are
+// rejected, as a stack is a linear sequence of commits.
+func (l *LocalStack) Load(ctx context.Context, c *Context) error {
+	c = c.withContext(ctx)
+	l.Commits = nil
+	return gitLogEach(c.cmd, c.config.UIDTrailers, func(commit *GitCommit) error {
+		if commit.IsMerge() {
+			return fmt.Errorf("local stack contains merge commit %s: %s, please rebase it onto %s", commit.Hash, commit.Oneline(), c.config.RemoteRef())
+		}
+		l.Commits = append(l.Commits, commit)
+		return nil
+	}, c.mergeBase+".."+c.config.LocalHead)
 }
@@ -1,6 +1,8 @@
 package stack
 
 import (
+	"context"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,11 +13,36 @@ func TestLocalStack(t *testing.T) {
 	t.Run("Load", func(t *testing.T) {
 		config := localRemoteRepo(t)
 		var localStack LocalStack
-		require.NoError(t, localStack.Load(config))
+		require.NoError(t, localStack.Load(context.Background(), config))
 		require.Len(t, localStack.Commits, 2)
 		assert.Equal(t, "D", localStack.Commits[0].Oneline())
 		assert.Equal(t, "Unique-D", localStack.Commits[0].UID)
 		assert.Equal(t, "C", localStack.Commits[1].Oneline())
 		assert.Equal(t, "", localStack.Commits[1].UID)
 	})
+	t.Run("merge", func(t *testing.T) {
+		env, cleanup := tmpCmdEnv(t)
+		t.Cleanup(func() { require.NoError(t, cleanup()) })
+		cmds := [][]string{{"git", "init"}}
+		cmds = append(cmds, createCommitCommands("A", "")...)
+		require.NoError(t, env.RunMulti(cmds...))
+		_, err := env.Run("git", "clone", ".", "local")
+		require.NoError(t, err)
+
+		local := env
+		local.Dir = filepath.Join(env.Dir, "local")
+		cmds = [][]string{{"git", "checkout", "-b", "side"}}
+		cmds = append(cmds, createCommitCommands("B", "")...)
+		cmds = append(cmds, []string{"git", "checkout", "-"})
+		cmds = append(cmds, createCommitCommands("C", "")...)
+		cmds = append(cmds, []string{"git", "merge", "--no-ff", "-m", "Merge side", "side"})
+		require.NoError(t, local.RunMulti(cmds...))
+
+		c, err := ContextOptions{Dir: local.Dir}.NewContext(context.Background())
+		require.NoError(t, err)
+		var localStack LocalStack
+		err = localStack.Load(context.Background(), c)
+		require.ErrorContains(t, err, "local stack contains merge commit")
+		require.ErrorContains(t, err, "Merge side, please rebase it onto origin/")
+	})
 }
@@ -0,0 +1,131 @@
+package stack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// repoLock is the lock of the repository under .git/gh-stack/lock, which is
+// held by commands that change remote branches or pull requests, so two of
+// them don't race on force-pushes and pull request updates. The lock is an
+// advisory lock of the open file, see lockFile, which is released when the
+// process exits, so a crashed process never leaves a stale lock behind. The
+// fields are written to the file by the holder only to report who holds it.
+type repoLock struct {
+	PID     int
+	Host    string
+	Command string
+	Time    time.Time
+	file    string
+	f       *os.File
+}
+
+// errLocked is returned by lockFile if the lock is held by another open file.
+var errLocked = errors.New("file is locked")
+
+// LockedError is returned if another process holds the lock of the
+// repository. The fields describing the holder are zero if it didn't write
+// them yet.
+type LockedError struct {
+	PID     int
+	Host    string
+	Command string
+	Since   time.Time
+	File    string
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("another gh-stack process holds %s, wait for it to finish", e.File)
+	}
+	return fmt.Sprintf("another gh-stack process (pid %d on %s) is running %q since %s, wait for it to finish",
+		e.PID, e.Host, e.Command, e.Since.Format("2006-01-02 15:04:05"))
+}
+
+// lockRepo takes the lock of the repository of the context, or returns a
+// *LockedError if another process holds it.
+func lockRepo(c *Context) (*repoLock, error) {
+	dir, err := gitCommonDir(c)
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, "gh-stack")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	l := &repoLock{
+		PID:     os.Getpid(),
+		Host:    host,
+		Command: strings.Join(os.Args, " "),
+		Time:    time.Now(),
+		file:    filepath.Join(dir, "lock"),
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(l.file, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); errors.Is(err, errLocked) {
+		f.Close()
+		holder, err := readRepoLock(l.file)
+		if err != nil {
+			// the holder didn't write the file yet
+			holder = &repoLock{}
+		}
+		return nil, &LockedError{PID: holder.PID, Host: holder.Host, Command: holder.Command, Since: holder.Time, File: l.file}
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.f = f
+	// the file still describes the previous holder if it crashed
+	if err := f.Truncate(0); err != nil {
+		l.Unlock()
+		return nil, err
+	} else if _, err := f.WriteAt(data, 0); err != nil {
+		l.Unlock()
+		return nil, err
+	}
+	c.log.Debug("took repository lock", "file", l.file)
+	return l, nil
+}
+
+func readRepoLock(file string) (*repoLock, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var l repoLock
+	return &l, json.Unmarshal(data, &l)
+}
+
+// Unlock releases the lock. It does nothing if the lock was released before,
+// so it can be deferred right after taking the lock.
+//
+// The file is emptied rather than removed, as a process that opened it before
+// could otherwise lock the removed file while another one creates a new one.
+func (l *repoLock) Unlock() error {
+	if l.f == nil {
+		return nil
+	}
+	f := l.f
+	l.f = nil
+	err := f.Truncate(0)
+	if uerr := unlockFile(f); err == nil {
+		err = uerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
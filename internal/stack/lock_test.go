@@ -0,0 +1,112 @@
+package stack
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockRepo(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+	_, err := env.Run("git", "init")
+	require.NoError(t, err)
+	c, err := ContextOptions{Dir: env.Dir, SkipMergeBase: true}.NewContext(context.Background())
+	require.NoError(t, err)
+	file := filepath.Join(env.Dir, ".git", "gh-stack", "lock")
+
+	t.Run("contended", func(t *testing.T) {
+		lock, err := lockRepo(c)
+		require.NoError(t, err)
+		holder, err := readRepoLock(file)
+		require.NoError(t, err)
+		require.Equal(t, os.Getpid(), holder.PID)
+
+		var locked *LockedError
+		_, err = lockRepo(c)
+		require.ErrorAs(t, err, &locked)
+		require.Equal(t, os.Getpid(), locked.PID)
+		require.Equal(t, lock.Command, locked.Command)
+		require.Equal(t, file, locked.File)
+		_, err = Sync(context.Background(), c)
+		require.ErrorAs(t, err, &locked)
+
+		require.NoError(t, lock.Unlock())
+		require.NoError(t, lock.Unlock())
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.Empty(t, data)
+		lock, err = lockRepo(c)
+		require.NoError(t, err)
+		require.NoError(t, lock.Unlock())
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		// the lock file of a process that crashed
+		cmd := exec.Command("true")
+		require.NoError(t, cmd.Run())
+		data, err := json.Marshal(repoLock{PID: cmd.Process.Pid, Host: "elsewhere", Command: "gh-stack sync", Time: time.Now()})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(file, data, 0600))
+
+		lock, err := lockRepo(c)
+		require.NoError(t, err)
+		holder, err := readRepoLock(file)
+		require.NoError(t, err)
+		require.Equal(t, os.Getpid(), holder.PID)
+		require.NoError(t, lock.Unlock())
+	})
+
+	t.Run("racing takeovers", func(t *testing.T) {
+		data, err := json.Marshal(repoLock{PID: 1 << 30, Host: "elsewhere", Command: "gh-stack sync", Time: time.Now()})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(file, data, 0600))
+
+		const n = 8
+		start := make(chan struct{})
+		locks := make(chan *repoLock, n)
+		errs := make(chan error, n)
+		for i := 0; i < n; i++ {
+			go func() {
+				<-start
+				lock, err := lockRepo(c)
+				if err != nil {
+					errs <- err
+					return
+				}
+				locks <- lock
+			}()
+		}
+		close(start)
+
+		var won []*repoLock
+		for i := 0; i < n; i++ {
+			select {
+			case lock := <-locks:
+				won = append(won, lock)
+			case err := <-errs:
+				var locked *LockedError
+				require.ErrorAs(t, err, &locked)
+			}
+		}
+		require.Len(t, won, 1)
+		require.NoError(t, won[0].Unlock())
+	})
+
+	t.Run("holder not written yet", func(t *testing.T) {
+		f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0600)
+		require.NoError(t, err)
+		defer f.Close()
+		require.NoError(t, lockFile(f))
+		defer unlockFile(f)
+
+		_, err = lockRepo(c)
+		require.EqualError(t, err, "another gh-stack process holds "+file+", wait for it to finish")
+	})
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package stack
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFileOffset is where the byte locked by lockFile is. It's past the
+// content of the file, as windows locks are mandatory and would prevent
+// others from reading the holder.
+const lockFileOffset = 1 << 32
+
+// lockFile takes an exclusive lock of f without waiting for it, or returns
+// errLocked if another open file holds it.
+func lockFile(f *os.File) error {
+	ol := &windows.Overlapped{OffsetHigh: lockFileOffset >> 32}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) error {
+	ol := &windows.Overlapped{OffsetHigh: lockFileOffset >> 32}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
@@ -21,13 +21,13 @@ func realMain() error {
 }
 
 func prInfo(owner, repo string) error {
-	config, err := readGhCLIConfig()
+	ctx := context.Background()
+	c, err := ContextOptions{LoadForge: true}.NewContext(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read gh config: %w", err)
+		return fmt.Errorf("failed to create context: %w", err)
 	}
 
-	token := (*config)["github.com"].OauthToken
-	ctx := context.Background()
+	token := c.config.Token
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
@@ -1,13 +1,16 @@
 package stack
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
 )
 
 var cleanups struct {
@@ -75,9 +78,18 @@ func localRemoteRepo(t *testing.T) *Context {
 		_, err = local.Run("git", "push", "origin", "D:refs/heads/gh-stack-commit-uid-d")
 		require.NoError(t, err)
 
-		ctx, err := ContextOptions{Dir: local.Dir, Verbose: true}.NewContext()
+		ctx, err := ContextOptions{Dir: local.Dir, Verbose: true}.NewContext(context.Background())
 		require.NoError(t, err)
 		_localRemoteRepo.ctx = ctx
 	})
 	return _localRemoteRepo.ctx
 }
+
+// newTestContext returns a minimal context operating in dir that discards its
+// logs, for testing code that doesn't need a merge base.
+func newTestContext(dir string, config Config) *Context {
+	c := &Context{config: config}
+	c.cmd.Dir = dir
+	c.log = slog.New(slog.HandlerOptions{}.NewTextHandler(io.Discard))
+	return c
+}
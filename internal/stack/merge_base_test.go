@@ -11,7 +11,7 @@ import (
 func TestMergeBase(t *testing.T) {
 	ctx := localRemoteRepo(t)
 
-	ref, err := MergeBase(ctx.cmd, "HEAD", "origin/main")
+	ref, err := MergeBase(ctx.cmd, "HEAD", ctx.config.RemoteRef())
 	require.NoError(t, err)
 
 	commit, err := ctx.cmd.Run("git", "show", "-q", ref, "--pretty=format:%s")
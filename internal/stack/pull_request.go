@@ -1,48 +1,33 @@
 package stack
 
-import (
-	"fmt"
-	"os"
-	"path"
-
-	"gopkg.in/yaml.v2"
-)
+import "context"
 
 type PullRequest struct {
-	ID    string
-	Title string
-	Body  string
-	Head  string
-	Base  string
-	URL   string
+	// Number is the number of the pull request, or 0 if it hasn't been
+	// created yet.
+	Number int
+	Title  string
+	Body   string
+	// Head is the branch the pull request is opened from, qualified with the
+	// owner of the push remote in the fork workflow.
+	Head string
+	// HeadSHA is the commit hash of the head branch as seen by the forge.
+	HeadSHA string
+	// Base is the branch the pull request is opened against.
+	Base string
+	URL  string
+	// State is "open", "closed" or "merged", or "" if the forge didn't
+	// report it.
+	State string
+	// Mergeable is "mergeable", "conflicting" or "unknown", or "" if the
+	// forge didn't report it.
+	Mergeable string
+	// CI is the combined status of the CI checks, see Forge.LoadStatus.
+	CI CIStatus
+	// Review is the review status, see Forge.LoadStatus.
+	Review ReviewStatus
 }
 
-func (p *PullRequest) LoadBranch(c *Context, branch string) error {
+func (p *PullRequest) LoadBranch(ctx context.Context, c *Context, branch string) error {
 	return nil
 }
-
-// gh cli config (https://cli.github.com)
-type ghCLIConfig map[string]struct {
-	User        string `yaml:"user"`
-	OauthToken  string `yaml:"oauth_token"`
-	GitProtocol string `yaml:"git_protocol"`
-}
-
-func readGhCLIConfig() (*ghCLIConfig, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %w", err)
-	}
-
-	f, err := os.Open(path.Join(homeDir, ".config", "gh", "hosts.yml"))
-	if err != nil {
-		return nil, fmt.Errorf("failed to open gh cli config file: %w", err)
-	}
-
-	var cfg ghCLIConfig
-	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse hub config file: %w", err)
-	}
-
-	return &cfg, nil
-}
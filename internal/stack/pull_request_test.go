@@ -1,17 +1,18 @@
 package stack
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestPullRequest(t *testing.T) {
-	c, err := (ContextOptions{LoadGithub: true}).NewContext()
+	c, err := (ContextOptions{LoadForge: true}).NewContext(context.Background())
 	require.NoError(t, err)
 
 	var pr PullRequest
-	require.NoError(t, pr.LoadBranch(c, "foo"))
+	require.NoError(t, pr.LoadBranch(context.Background(), c, "foo"))
 	//
 	// ctx := context.Background()
 	// ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
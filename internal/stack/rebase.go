@@ -0,0 +1,63 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rebase fetches the remotes and starts an interactive rebase of the local
+// stack onto the target branch. git takes over the terminal to edit the todo
+// list and the commit messages. If the rebase stops for a conflict, the
+// returned error says how to go on from there.
+//
+// Rebase holds the lock of the repository while it runs, so no sync pushes
+// the stack while it is rewritten, and it returns an *InterruptedError if the
+// last sync was interrupted, as ResumeSync requires the same local stack.
+func Rebase(ctx context.Context, c *Context) error {
+	c = c.withContext(ctx)
+	lock, err := lockRepo(c)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if err := checkJournal(c); err != nil {
+		return err
+	} else if err := gitFetch(c); err != nil {
+		return err
+	}
+	args := []string{"git", "rebase", "--interactive", c.config.RemoteRef()}
+	if c.config.LocalHead != "HEAD" {
+		// git checks out the branch before rebasing it
+		args = append(args, c.config.LocalHead)
+	}
+	err = c.cmd.RunInteractive(args...)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	if stopped, serr := rebaseInProgress(c); serr != nil {
+		return serr
+	} else if stopped {
+		return fmt.Errorf("rebase stopped, resolve the conflicts and run `git rebase --continue`, or `git rebase --abort` to give up: %w", err)
+	}
+	return err
+}
+
+// rebaseInProgress returns true if a rebase stopped and waits for the user.
+func rebaseInProgress(c *Context) (bool, error) {
+	out, err := c.cmd.Run("git", "rev-parse", "--git-path", "rebase-merge")
+	if err != nil {
+		return false, err
+	}
+	dir := strings.TrimSpace(out)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.cmd.Dir, dir)
+	}
+	_, err = os.Stat(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,86 @@
+package stack
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebase(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+
+	upstream := env
+	upstream.Dir = filepath.Join(env.Dir, "upstream")
+	require.NoError(t, env.RunMulti([]string{"mkdir", "-p", upstream.Dir}))
+	cmds := [][]string{{"git", "init"}}
+	cmds = append(cmds, createCommitCommands("A", "")...)
+	require.NoError(t, upstream.RunMulti(cmds...))
+	_, err := env.Run("git", "clone", "./upstream", "local")
+	require.NoError(t, err)
+	local := env
+	local.Dir = filepath.Join(env.Dir, "local")
+	cmds = createCommitCommands("B", "uid-b")
+	cmds = append(cmds, createCommitCommands("C", "uid-c")...)
+	require.NoError(t, local.RunMulti(cmds...))
+	require.NoError(t, upstream.RunMulti(createCommitCommands("D", "")...))
+
+	newContext := func(t *testing.T) *Context {
+		t.Helper()
+		c, err := ContextOptions{Dir: local.Dir}.NewContext(context.Background())
+		require.NoError(t, err)
+		// accept the todo list as is instead of opening an editor
+		c.cmd.Env = append(c.cmd.Env, "GIT_SEQUENCE_EDITOR=true")
+		return c
+	}
+
+	require.NoError(t, Rebase(context.Background(), newContext(t)))
+	c := newContext(t)
+	var ls LocalStack
+	require.NoError(t, ls.Load(context.Background(), c))
+	require.Len(t, ls.Commits, 2)
+	require.Equal(t, "C", ls.Commits[0].Oneline())
+	require.Equal(t, "B", ls.Commits[1].Oneline())
+	remote, err := upstream.Run("git", "rev-parse", "HEAD")
+	require.NoError(t, err)
+	require.Equal(t, remote, c.mergeBase+"\n")
+
+	t.Run("locked", func(t *testing.T) {
+		c := newContext(t)
+		lock, err := lockRepo(c)
+		require.NoError(t, err)
+		defer lock.Unlock()
+		var locked *LockedError
+		require.ErrorAs(t, Rebase(context.Background(), c), &locked)
+	})
+
+	t.Run("interrupted sync", func(t *testing.T) {
+		c := newContext(t)
+		op, err := beginOperation(c, "sync")
+		require.NoError(t, err)
+		defer os.Remove(op.file)
+		var interrupted *InterruptedError
+		require.ErrorAs(t, Rebase(context.Background(), c), &interrupted)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		require.NoError(t, upstream.RunMulti(
+			[]string{"sh", "-c", "echo upstream > E"},
+			[]string{"git", "add", "E"},
+			[]string{"git", "commit", "-m", "E upstream"},
+		))
+		require.NoError(t, local.RunMulti(
+			[]string{"sh", "-c", "echo local > E"},
+			[]string{"git", "add", "E"},
+			[]string{"git", "commit", "-m", "E local"},
+		))
+
+		err := Rebase(context.Background(), newContext(t))
+		require.ErrorContains(t, err, "rebase stopped, resolve the conflicts and run `git rebase --continue`")
+		_, err = local.Run("git", "rebase", "--abort")
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,87 @@
+package stack
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// LoadRemote fills in RemoteHost, RemoteOwner and RemoteRepo from the url of
+// the configured remote, as well as PushOwner and PushRepo from the url of the
+// push remote if it differs. Values that are already set are left untouched,
+// and remotes whose url can't be parsed are skipped.
+func (c *Config) LoadRemote(ctx *Context) error {
+	d := c.WithDefaults()
+	if host, owner, repo, ok := loadRemoteURL(ctx, d.RemoteName); ok {
+		source := "git remote " + d.RemoteName
+		c.setDefault(&c.RemoteHost, "remote_host", host, source)
+		c.setDefault(&c.RemoteOwner, "remote_owner", owner, source)
+		c.setDefault(&c.RemoteRepo, "remote_repo", repo, source)
+	}
+	if d.PushRemoteName == d.RemoteName {
+		return nil
+	}
+	if _, owner, repo, ok := loadRemoteURL(ctx, d.PushRemoteName); ok {
+		source := "git remote " + d.PushRemoteName
+		c.setDefault(&c.PushOwner, "push_owner", owner, source)
+		c.setDefault(&c.PushRepo, "push_repo", repo, source)
+	}
+	return nil
+}
+
+// loadRemoteURL returns the host, owner and repo of the remote with the given
+// name, or false if they can't be determined.
+func loadRemoteURL(ctx *Context, name string) (host, owner, repo string, ok bool) {
+	out, err := ctx.cmd.Run("git", "remote", "get-url", name)
+	if err != nil {
+		ctx.log.Debug("failed to get remote url, skipping", "remote", name, "err", err)
+		return "", "", "", false
+	}
+
+	remoteURL := strings.TrimSpace(out)
+	host, owner, repo, err = parseRemoteURL(remoteURL)
+	if err != nil {
+		ctx.log.Debug("failed to parse remote url, skipping", "url", remoteURL, "err", err)
+		return "", "", "", false
+	}
+	ctx.log.Debug("loaded remote", "remote", name, "url", remoteURL, "host", host, "owner", owner, "repo", repo)
+	return host, owner, repo, true
+}
+
+// scpURLPattern matches scp-like urls such as git@github.com:owner/repo.git.
+var scpURLPattern = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// parseRemoteURL extracts the host, owner and repository name from a git
+// remote url. The ssh, git, http(s) and scp-like url formats are supported.
+// Nested namespaces, as used by GitLab, are returned as part of the owner.
+func parseRemoteURL(remoteURL string) (host, owner, repo string, err error) {
+	var path string
+	if strings.Contains(remoteURL, "://") {
+		u, err := url.Parse(remoteURL)
+		if err != nil {
+			return "", "", "", err
+		}
+		switch u.Scheme {
+		case "https", "http":
+			host = u.Host
+		case "ssh", "git", "git+ssh", "ssh+git":
+			// the port is used for ssh, not for talking to the api
+			host = u.Hostname()
+		default:
+			return "", "", "", fmt.Errorf("unsupported remote url scheme: %q", u.Scheme)
+		}
+		path = u.Path
+	} else if m := scpURLPattern.FindStringSubmatch(remoteURL); m != nil {
+		host, path = m[1], m[2]
+	} else {
+		return "", "", "", fmt.Errorf("unsupported remote url: %q", remoteURL)
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	i := strings.LastIndex(path, "/")
+	if host == "" || i <= 0 || i == len(path)-1 {
+		return "", "", "", fmt.Errorf("unsupported remote url: %q", remoteURL)
+	}
+	return host, path[:i], path[i+1:], nil
+}
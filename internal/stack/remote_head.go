@@ -0,0 +1,63 @@
+package stack
+
+import (
+	"strings"
+)
+
+// LoadRemoteHead sets RemoteHead to the branch targeted by the local stack
+// unless it is already configured. It is taken from the first of the
+// following sources that provides it:
+//
+//  1. The upstream of LocalHead, if it is a branch of the remote.
+//  2. The symbolic ref refs/remotes/<remote>/HEAD.
+//  3. The default branch of the repository according to the forge api.
+//
+// RemoteHead is left empty if none of the sources provides a branch.
+func (c *Config) LoadRemoteHead(ctx *Context) error {
+	if c.RemoteHead != "" {
+		return nil
+	}
+
+	prefix := "refs/remotes/" + c.RemoteName + "/"
+	upstream, err := ctx.cmd.Run("git", "rev-parse", "--symbolic-full-name", c.LocalHead+"@{upstream}")
+	if err != nil && !IsUnknownRevision(err) {
+		return err
+	} else if err != nil {
+		ctx.log.Debug("no upstream, skipping", "err", err)
+	} else if branch, ok := strings.CutPrefix(strings.TrimSpace(upstream), prefix); !ok {
+		ctx.log.Debug("upstream is not a branch of the remote, skipping", "upstream", strings.TrimSpace(upstream))
+	} else if strings.HasPrefix(branch, branchPrefix) {
+		ctx.log.Debug("upstream is a gh-stack branch, skipping", "upstream", branch)
+	} else {
+		c.setRemoteHead(ctx, branch, "upstream")
+		return nil
+	}
+
+	head, err := ctx.cmd.Run("git", "symbolic-ref", prefix+"HEAD")
+	if err != nil && !IsUnknownRevision(err) {
+		return err
+	} else if err != nil {
+		ctx.log.Debug("no remote HEAD, skipping", "err", err)
+	} else if branch, ok := strings.CutPrefix(strings.TrimSpace(head), prefix); ok {
+		c.setRemoteHead(ctx, branch, prefix+"HEAD")
+		return nil
+	}
+
+	if ctx.forge == nil {
+		ctx.log.Debug("no forge, skipping default branch lookup")
+		return nil
+	}
+	branch, err := ctx.forge.DefaultBranch(ctx.cmd.Context())
+	if err != nil {
+		ctx.log.Debug("failed to get default branch, skipping", "err", err)
+	} else if branch != "" {
+		c.setRemoteHead(ctx, branch, c.Forge+" default branch")
+	}
+	return nil
+}
+
+func (c *Config) setRemoteHead(ctx *Context, branch, source string) {
+	c.RemoteHead = branch
+	c.setSource("remote_head", source)
+	ctx.log.Debug("detected remote head", "branch", branch, "source", source)
+}
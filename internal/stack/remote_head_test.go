@@ -0,0 +1,85 @@
+package stack
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRemoteHead(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+
+	remote := env
+	remote.Dir = filepath.Join(env.Dir, "remote")
+	cmds := [][]string{{"mkdir", "-p", remote.Dir}}
+	require.NoError(t, env.RunMulti(cmds...))
+	cmds = [][]string{{"git", "init", "--initial-branch=develop"}}
+	cmds = append(cmds, createCommitCommands("A", "")...)
+	cmds = append(cmds, []string{"git", "branch", "release"})
+	require.NoError(t, remote.RunMulti(cmds...))
+
+	_, err := env.Run("git", "clone", "./remote", "local")
+	require.NoError(t, err)
+	local := env
+	local.Dir = filepath.Join(env.Dir, "local")
+
+	load := func(t *testing.T, c *Context) (string, string) {
+		t.Helper()
+		config := c.config.WithDefaults()
+		require.NoError(t, config.LoadRemoteHead(c))
+		return config.RemoteHead, config.sources["remote_head"]
+	}
+
+	t.Run("configured", func(t *testing.T) {
+		branch, _ := load(t, newTestContext(local.Dir, Config{RemoteHead: "trunk"}))
+		require.Equal(t, "trunk", branch)
+	})
+
+	t.Run("upstream", func(t *testing.T) {
+		_, err := local.Run("git", "checkout", "-b", "feature", "--track", "origin/release")
+		require.NoError(t, err)
+		branch, source := load(t, newTestContext(local.Dir, Config{}))
+		require.Equal(t, "release", branch)
+		require.Equal(t, "upstream", source)
+	})
+
+	t.Run("remote HEAD", func(t *testing.T) {
+		_, err := local.Run("git", "checkout", "--detach")
+		require.NoError(t, err)
+		branch, source := load(t, newTestContext(local.Dir, Config{}))
+		require.Equal(t, "develop", branch)
+		require.Equal(t, "refs/remotes/origin/HEAD", source)
+	})
+
+	t.Run("github default branch", func(t *testing.T) {
+		_, err := local.Run("git", "remote", "set-head", "origin", "--delete")
+		require.NoError(t, err)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/repos/acme/widgets", r.URL.Path)
+			fmt.Fprint(w, `{"default_branch": "trunk"}`)
+		}))
+		defer server.Close()
+
+		client := github.NewClient(nil)
+		client.BaseURL, err = url.Parse(server.URL + "/")
+		require.NoError(t, err)
+		c := newTestContext(local.Dir, Config{Forge: "github"})
+		c.forge = &githubForge{client: client, owner: "acme", repo: "widgets"}
+		branch, source := load(t, c)
+		require.Equal(t, "trunk", branch)
+		require.Equal(t, "github default branch", source)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		branch, _ := load(t, newTestContext(local.Dir, Config{}))
+		require.Equal(t, "", branch)
+	})
+}
@@ -1,32 +1,121 @@
 package stack
 
+import (
+	"context"
+	"strings"
+)
+
 type RemoteStacks struct {
 	Stacks []*RemoteStack
 }
 
-func (r *RemoteStacks) Load(c *Context, ls *LocalStack) error {
+// Load populates the remote stacks of the commits of the local stack that
+// have a branch on the push remote. Regardless of the size of the stack, it
+// runs one git for-each-ref to find the branches and one git log to read the
+// commits of all of them.
+func (r *RemoteStacks) Load(ctx context.Context, c *Context, ls *LocalStack) error {
+	c = c.withContext(ctx)
 	r.Stacks = []*RemoteStack{}
+	tips, err := remoteBranchTips(c, c.config.PushRemoteName)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"^" + c.mergeBase}
 	for _, localCommit := range ls.Commits {
 		if localCommit.UID == "" {
 			// skip commits without UID
 			continue
 		}
-
-		branch := localCommit.Branch()
-		remoteCommits, err := GitLog(c.cmd, c.mergeBase+".."+c.config.RemoteName+"/"+branch)
-		if err != nil {
+		tip, ok := tips[localCommit.Branch()]
+		if !ok {
 			// the remote branch does not exist
 			continue
 		}
+		args = append(args, tip)
 		r.Stacks = append(r.Stacks, &RemoteStack{
-			UID:     localCommit.UID,
-			Branch:  branch,
-			Commits: remoteCommits,
+			UID:    localCommit.UID,
+			Branch: localCommit.Branch(),
 		})
 	}
+	if len(r.Stacks) == 0 {
+		return nil
+	}
+
+	// commits holds the commits of all remote branches in git log order, so
+	// the commits of each branch can be picked in that order, too.
+	var commits []*GitCommit
+	byHash := map[string]*GitCommit{}
+	err = gitLogEach(c.cmd, c.config.UIDTrailers, func(commit *GitCommit) error {
+		commits = append(commits, commit)
+		byHash[commit.Hash] = commit
+		return nil
+	}, args...)
+	if err != nil {
+		return err
+	}
+
+	for i, stack := range r.Stacks {
+		reachable := map[string]bool{}
+		pending := []string{args[i+1]}
+		for len(pending) > 0 {
+			hash := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			commit, ok := byHash[hash]
+			if !ok || reachable[hash] {
+				// reached the merge base, or already visited
+				continue
+			}
+			reachable[hash] = true
+			pending = append(pending, commit.Parents...)
+		}
+		for _, commit := range commits {
+			if reachable[commit.Hash] {
+				stack.Commits = append(stack.Commits, commit)
+			}
+		}
+	}
 	return nil
 }
 
+// remoteBranchTips returns the hashes of the remote-tracking branches created
+// by gh-stack for the push remote, keyed by branch name.
+func remoteBranchTips(c *Context, remote string) (map[string]string, error) {
+	prefix := "refs/remotes/" + remote + "/"
+	out, err := c.cmd.Run("git", "for-each-ref", "--format=%(objectname) %(refname)", prefix+branchPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	tips := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		hash, ref, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		tips[strings.TrimPrefix(ref, prefix)] = hash
+	}
+	return tips, nil
+}
+
+// lsRemoteBranches returns the hashes of the stack branches of the given
+// remote as the remote has them now, which the remote-tracking branches of
+// remoteBranchTips only have as of the last fetch.
+func lsRemoteBranches(c *Context, remote string) (map[string]string, error) {
+	out, err := c.cmd.Run("git", "ls-remote", "--heads", remote, "refs/heads/"+branchPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	tips := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		hash, ref, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		tips[strings.TrimPrefix(ref, "refs/heads/")] = hash
+	}
+	return tips, nil
+}
+
 type RemoteStack struct {
 	UID     string
 	Branch  string
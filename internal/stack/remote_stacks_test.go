@@ -0,0 +1,31 @@
+package stack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteStacks(t *testing.T) {
+	t.Run("Load", func(t *testing.T) {
+		c := localRemoteRepo(t)
+		var ls LocalStack
+		require.NoError(t, ls.Load(context.Background(), c))
+		var remoteStacks RemoteStacks
+		require.NoError(t, remoteStacks.Load(context.Background(), c, &ls))
+
+		// E has a UID but no branch, F has no UID
+		require.Len(t, remoteStacks.Stacks, 2)
+		d, cc := remoteStacks.Stacks[0], remoteStacks.Stacks[1]
+		require.Equal(t, "uid-d", d.UID)
+		require.Equal(t, "gh-stack-commit-uid-d", d.Branch)
+		require.Len(t, d.Commits, 2)
+		require.Equal(t, "D", d.Commits[0].Oneline())
+		require.Equal(t, "C", d.Commits[1].Oneline())
+		require.Equal(t, "uid-c", cc.UID)
+		require.Len(t, cc.Commits, 1)
+		require.Equal(t, "C", cc.Commits[0].Oneline())
+		require.Same(t, d.Commits[1], cc.Commits[0])
+	})
+}
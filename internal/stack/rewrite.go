@@ -0,0 +1,162 @@
+package stack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RewriteMessages replaces the messages of the commits in the local stack with
+// the messages returned by fn, which may return the message unchanged.
+//
+// Instead of rebasing, the commits are recreated with git commit-tree on their
+// original trees, and LocalHead is moved to the new top of the stack with git
+// update-ref. The working tree and index are not touched, so this works with
+// uncommitted changes and without checking out any commits. The authors of the
+// commits are preserved, while the committer becomes the current user, just
+// like with a rebase.
+//
+// The commits of the local stack are updated in place. It returns the number
+// of commits whose message was changed.
+func RewriteMessages(c *Context, ls *LocalStack, fn func(*GitCommit) (string, error)) (int, error) {
+	type rewrite struct {
+		commit *GitCommit
+		hash   string
+	}
+	if len(ls.Commits) == 0 {
+		return 0, nil
+	}
+	oldHead := ls.Commits[0].Hash
+	var rewrites []rewrite
+	var rewritten int
+	parent := c.mergeBase
+	// the local stack is ordered from top to bottom
+	for i := len(ls.Commits) - 1; i >= 0; i-- {
+		commit := ls.Commits[i]
+		msg, err := fn(commit)
+		if err != nil {
+			return 0, err
+		}
+		if msg == commit.Message && len(rewrites) == 0 {
+			parent = commit.Hash
+			continue
+		} else if msg != commit.Message {
+			rewritten++
+		}
+
+		hash, err := commitTree(c, commit, parent, msg)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", commit.Hash, err)
+		}
+		c.log.Debug("rewrote commit", "old", commit.Hash, "new", hash)
+		rewrites = append(rewrites, rewrite{commit: commit, hash: hash})
+		parent = hash
+	}
+	if len(rewrites) == 0 {
+		return 0, nil
+	}
+
+	// passing the old value guards against changes of the ref since the local
+	// stack was loaded
+	_, err := c.cmd.Run("git", "update-ref", "-m", "gh-stack: rewrite commit messages", c.config.LocalHead, parent, oldHead)
+	if err != nil {
+		return 0, err
+	}
+
+	// read the new commits back to pick up their committer and parents
+	args := []string{"--no-walk=unsorted"}
+	for _, r := range rewrites {
+		args = append(args, r.hash)
+	}
+	commits, err := GitLog(c.cmd, c.config.UIDTrailers, args...)
+	if err != nil {
+		return rewritten, err
+	} else if len(commits) != len(rewrites) {
+		return rewritten, fmt.Errorf("read %d of %d rewritten commits", len(commits), len(rewrites))
+	}
+	for i, r := range rewrites {
+		*r.commit = *commits[i]
+	}
+	return rewritten, nil
+}
+
+// AssignUIDs adds a trailer with a new UID to every commit of the local stack
+// that doesn't have a UID yet, see RewriteMessages. The key of the trailer is
+// the first of the UIDTrailers, see formatUID.
+//
+// UIDs that are already used by the local stack or by a branch of the push
+// remote are skipped, as deterministic generators like the seeded one start
+// their sequence again in every process, and two commits with the same UID
+// would be pushed to the same branch.
+func AssignUIDs(c *Context, ls *LocalStack) (int, error) {
+	used, err := usedUIDs(c, ls)
+	if err != nil {
+		return 0, err
+	}
+	return RewriteMessages(c, ls, func(commit *GitCommit) (string, error) {
+		if commit.UID != "" {
+			return commit.Message, nil
+		}
+		key := c.config.UIDTrailers[0]
+		uid, err := newUnusedUID(c.uids, key, used)
+		if err != nil {
+			return "", err
+		}
+		used[uid] = true
+		env := c.cmd
+		env.Stdin = strings.NewReader(commit.Message)
+		msg, err := env.Run("git", "interpret-trailers", "--trailer", key+": "+uid)
+		return strings.TrimSpace(msg), err
+	})
+}
+
+// maxUIDAttempts is how many UIDs newUnusedUID generates at most.
+const maxUIDAttempts = 1000
+
+// newUnusedUID returns the first UID generated by g that isn't used, formatted
+// for a trailer with the given key.
+func newUnusedUID(g UIDGenerator, key string, used map[string]bool) (string, error) {
+	for i := 0; i < maxUIDAttempts; i++ {
+		uid, err := g.NewUID()
+		if err != nil {
+			return "", err
+		}
+		if uid = formatUID(key, uid); !used[uid] {
+			return uid, nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate an unused UID in %d attempts", maxUIDAttempts)
+}
+
+// usedUIDs returns the UIDs of the local stack and of the branches of the push
+// remote as of the last fetch.
+func usedUIDs(c *Context, ls *LocalStack) (map[string]bool, error) {
+	used := map[string]bool{}
+	for _, commit := range ls.Commits {
+		if commit.UID != "" {
+			used[commit.UID] = true
+		}
+	}
+	tips, err := remoteBranchTips(c, c.config.PushRemoteName)
+	if err != nil {
+		return nil, err
+	}
+	for branch := range tips {
+		used[strings.TrimPrefix(branch, branchPrefix)] = true
+	}
+	return used, nil
+}
+
+// commitTree creates a commit with the tree and author of the given commit,
+// but with the given parent and message, and returns its hash.
+func commitTree(c *Context, commit *GitCommit, parent, msg string) (string, error) {
+	env := c.cmd
+	env.Stdin = strings.NewReader(msg)
+	env.Env = append(append([]string{}, env.Env...),
+		"GIT_AUTHOR_NAME="+commit.Author.Name,
+		"GIT_AUTHOR_EMAIL="+commit.Author.Email,
+		"GIT_AUTHOR_DATE="+commit.AuthorDate.Format(time.RFC3339),
+	)
+	out, err := env.Run("git", "commit-tree", commit.Tree, "-p", parent, "-F", "-")
+	return strings.TrimSpace(out), err
+}
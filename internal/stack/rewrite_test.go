@@ -0,0 +1,103 @@
+package stack
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssignUIDs(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+
+	upstream := env
+	upstream.Dir = filepath.Join(env.Dir, "upstream")
+	require.NoError(t, env.RunMulti([]string{"mkdir", "-p", upstream.Dir}))
+	cmds := [][]string{{"git", "init"}}
+	cmds = append(cmds, createCommitCommands("A", "")...)
+	require.NoError(t, upstream.RunMulti(cmds...))
+
+	local := env
+	local.Dir = filepath.Join(env.Dir, "local")
+	_, err := env.Run("git", "clone", "./upstream", "local")
+	require.NoError(t, err)
+	cmds = nil
+	cmds = append(cmds, createCommitCommands("B", "uid-b")...)
+	cmds = append(cmds, createCommitCommands("C", "")...)
+	cmds = append(cmds, createCommitCommands("D", "")...)
+	require.NoError(t, local.RunMulti(cmds...))
+
+	// a dirty working tree and index must survive the rewrite
+	require.NoError(t, os.WriteFile(filepath.Join(local.Dir, "C"), []byte("modified"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(local.Dir, "E"), []byte("staged"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(local.Dir, "F"), []byte("untracked"), 0644))
+	_, err = local.Run("git", "add", "E")
+	require.NoError(t, err)
+	status, err := local.Run("git", "status", "--porcelain")
+	require.NoError(t, err)
+
+	c, err := ContextOptions{Dir: local.Dir}.NewContext(context.Background())
+	require.NoError(t, err)
+	c.uids = newSeededUIDGenerator(1)
+
+	var ls LocalStack
+	require.NoError(t, ls.Load(context.Background(), c))
+	var old []*GitCommit
+	for _, commit := range ls.Commits {
+		cp := *commit
+		old = append(old, &cp)
+	}
+	oldTrees, err := local.Run("git", "log", "--format=%T %an %ae %ad", c.mergeBase+".."+c.config.LocalHead)
+	require.NoError(t, err)
+
+	n, err := AssignUIDs(c, &ls)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	// the bottom commit already had a UID and is kept as is
+	require.Len(t, ls.Commits, 3)
+	require.Equal(t, old[2].Hash, ls.Commits[2].Hash)
+	require.Equal(t, "uid-b", ls.Commits[2].UID)
+	require.Equal(t, "4d65822107fcfd52", ls.Commits[1].UID)
+	require.Equal(t, "78629a0f5f3f164f", ls.Commits[0].UID)
+	require.Equal(t, "C\n\nThis is commit: C\nCommit-UID: 4d65822107fcfd52", ls.Commits[1].Message)
+
+	// the commits on disk match the updated local stack
+	var reloaded LocalStack
+	require.NoError(t, reloaded.Load(context.Background(), c))
+	require.Equal(t, ls.Commits, reloaded.Commits)
+	trees, err := local.Run("git", "log", "--format=%T %an %ae %ad", c.mergeBase+".."+c.config.LocalHead)
+	require.NoError(t, err)
+	require.Equal(t, oldTrees, trees)
+
+	status2, err := local.Run("git", "status", "--porcelain")
+	require.NoError(t, err)
+	require.Equal(t, status, status2)
+	data, err := os.ReadFile(filepath.Join(local.Dir, "C"))
+	require.NoError(t, err)
+	require.Equal(t, "modified", string(data))
+
+	reflog, err := local.Run("git", "reflog", "-1", "--format=%gs", c.config.LocalHead)
+	require.NoError(t, err)
+	require.Equal(t, "gh-stack: rewrite commit messages", strings.TrimSpace(reflog))
+
+	// nothing left to assign
+	n, err = AssignUIDs(c, &ls)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	// a local head that moved since loading the stack is not clobbered
+	stale := LocalStack{Commits: old}
+	_, err = RewriteMessages(c, &stale, func(commit *GitCommit) (string, error) {
+		return commit.Message + "\n\nReworded", nil
+	})
+	require.Error(t, err)
+	require.NotEqual(t, ls.Commits[0].Hash, old[0].Hash)
+	require.Equal(t, old[0].Hash, stale.Commits[0].Hash)
+	require.NoError(t, reloaded.Load(context.Background(), c))
+	require.Equal(t, ls.Commits, reloaded.Commits)
+}
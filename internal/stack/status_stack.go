@@ -2,23 +2,59 @@ package stack
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 type StatusStack struct {
 	LocalStack   LocalStack
 	RemoteStacks RemoteStacks
 	StatusItems  []*StatusItem
+	// Offline is true if the remotes were not fetched and the pull requests
+	// were taken from the snapshot of the last online status, so neither may
+	// be up to date.
+	Offline bool
+	// FetchedAt is when the remotes were last fetched, or zero if unknown.
+	FetchedAt time.Time
+	// PullRequestsFetchedAt is when the pull requests were loaded from the
+	// forge, or zero if they weren't. Offline it's when the least recently
+	// loaded of them was.
+	PullRequestsFetchedAt time.Time
+	// Interrupted is the last operation of the journal if it didn't
+	// complete, see Operation.Done.
+	Interrupted *Operation
 }
 
-func (s *StatusStack) Load(c *Context) error {
-	if err := gitFetch(c); err != nil {
+// Load loads the local and remote stacks and pairs their commits. The remotes
+// are fetched first, unless the context is offline, in which case the
+// remote-tracking refs of the last fetch are used.
+func (s *StatusStack) Load(ctx context.Context, c *Context) error {
+	c = c.withContext(ctx)
+	s.Offline = c.offline
+	if !c.offline {
+		if err := gitFetch(c); err != nil {
+			return err
+		}
+	}
+	if dir, err := gitCommonDir(c); err != nil {
+		return err
+	} else if fi, err := os.Stat(filepath.Join(dir, "FETCH_HEAD")); err == nil {
+		s.FetchedAt = fi.ModTime()
+	}
+	if op, err := LastOperation(c); err != nil {
 		return err
+	} else if op != nil && !op.Done {
+		s.Interrupted = op
 	}
-	if err := s.LocalStack.Load(c); err != nil {
+	if err := s.LocalStack.Load(ctx, c); err != nil {
 		return err
 	}
-	if err := s.RemoteStacks.Load(c, &s.LocalStack); err != nil {
+	if err := s.RemoteStacks.Load(ctx, c, &s.LocalStack); err != nil {
 		return err
 	}
 
@@ -52,14 +88,145 @@ func (s *StatusStack) Load(c *Context) error {
 	return nil
 }
 
+// LoadPullRequests loads the pull request and its status for every item with
+// a UID from the forge. Forges implementing batchForge load all of them in a
+// single request, even if their REST lookups are cached, as that is a single
+// round trip. Otherwise, or if that fails, up to Concurrency items are loaded at the same
+// time. Failures are recorded in the Err of the item instead of aborting the
+// other items, only the cancellation of ctx is returned as an error.
+//
+// An offline context loads the pull requests from the snapshot saved by the
+// last online call instead, see loadCachedPullRequests.
+func (s *StatusStack) LoadPullRequests(ctx context.Context, c *Context) error {
+	if c.offline {
+		return s.loadCachedPullRequests(c)
+	} else if c.forge == nil {
+		return errors.New("no forge configured")
+	}
+	if err := s.fetchPullRequests(ctx, c); err != nil {
+		return err
+	}
+
+	s.PullRequestsFetchedAt = time.Now()
+	prs := map[string]*PullRequest{}
+	for _, item := range s.StatusItems {
+		if item.UID != "" && item.Err == nil {
+			prs[c.config.PullRequestHead(item.LocalCommit.Branch())] = item.PullRequest
+		}
+	}
+	if err := c.httpCache.SavePullRequests(prs, s.PullRequestsFetchedAt); err != nil {
+		c.log.Debug("failed to save pull request snapshot", "err", err)
+	}
+	return nil
+}
+
+// errNotCached is the Err of offline items without a cached pull request.
+var errNotCached = errors.New("pull request not cached, run status online")
+
+// loadCachedPullRequests loads the pull requests from the snapshot of the
+// last online status of each. Items that are not part of it get errNotCached.
+func (s *StatusStack) loadCachedPullRequests(c *Context) error {
+	prs, err := c.httpCache.LoadPullRequests()
+	if err != nil {
+		return fmt.Errorf("failed to load cached pull requests: %w", err)
+	}
+	s.PullRequestsFetchedAt = time.Time{}
+	for _, item := range s.StatusItems {
+		if item.UID == "" {
+			continue
+		}
+		entry, ok := prs[c.config.PullRequestHead(item.LocalCommit.Branch())]
+		if !ok {
+			item.Err = errNotCached
+			continue
+		}
+		item.PullRequest = entry.PullRequest
+		if s.PullRequestsFetchedAt.IsZero() || entry.FetchedAt.Before(s.PullRequestsFetchedAt) {
+			s.PullRequestsFetchedAt = entry.FetchedAt
+		}
+	}
+	return nil
+}
+
+// fetchPullRequests loads the pull requests of all items from the forge.
+func (s *StatusStack) fetchPullRequests(ctx context.Context, c *Context) error {
+	if batch, ok := c.forge.(batchForge); ok {
+		err := s.loadPullRequestsBatch(ctx, c, batch)
+		if err == nil || ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.log.Debug("failed to load pull requests in one request, falling back to one by one", "err", err)
+	}
+
+	items := make(chan *StatusItem)
+	var wg sync.WaitGroup
+	for i := 0; i < c.config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				item.PullRequest, item.Err = loadPullRequest(ctx, c, item)
+			}
+		}()
+	}
+
+	// items are handed out in stack order, and each worker only writes to
+	// the item it took, so the items stay in stack order as well
+feed:
+	for _, item := range s.StatusItems {
+		if item.UID == "" {
+			continue
+		}
+		select {
+		case items <- item:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(items)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// loadPullRequestsBatch loads the pull requests of all items with a UID in a
+// single request.
+func (s *StatusStack) loadPullRequestsBatch(ctx context.Context, c *Context, batch batchForge) error {
+	var heads []string
+	for _, item := range s.StatusItems {
+		if item.UID != "" {
+			heads = append(heads, c.config.PullRequestHead(item.LocalCommit.Branch()))
+		}
+	}
+	prs, err := batch.FindPullRequests(ctx, heads)
+	if err != nil {
+		return err
+	}
+	for _, item := range s.StatusItems {
+		if item.UID != "" {
+			item.PullRequest = prs[c.config.PullRequestHead(item.LocalCommit.Branch())]
+		}
+	}
+	return nil
+}
+
+// loadPullRequest returns the pull request of the given item including its
+// status, or nil if there is none.
+func loadPullRequest(ctx context.Context, c *Context, item *StatusItem) (*PullRequest, error) {
+	head := c.config.PullRequestHead(item.LocalCommit.Branch())
+	pr, err := c.forge.FindPullRequest(ctx, head)
+	if err != nil || pr == nil {
+		return nil, err
+	}
+	if err := c.forge.LoadStatus(ctx, pr); err != nil {
+		return pr, fmt.Errorf("failed to load status of %s: %w", pr.URL, err)
+	}
+	return pr, nil
+}
+
 func (s *StatusStack) String() string {
 	var buf bytes.Buffer
 	for _, item := range s.StatusItems {
-		status := "new"
-		if item.RemoteStack != nil {
-			status = "modified"
-		}
-		fmt.Fprintf(&buf, "%s %s\n", status, item.Oneline)
+		fmt.Fprintf(&buf, "%s %s\n", item.Status(), item.Oneline)
 	}
 	return buf.String()
 }
@@ -70,4 +237,27 @@ type StatusItem struct {
 	LocalCommit *GitCommit
 	RemoteStack *RemoteStack
 	PullRequest *PullRequest
+	// Err is the error that occurred while loading the pull request, if any.
+	Err error
+}
+
+// Status returns the status of the local commit compared to the head of its
+// remote branch: "new" if there is no remote branch, "unchanged" if it is
+// the same commit, "rebased" if only the parent differs, "reworded" if the
+// tree is the same but the message differs, and "changed" otherwise.
+func (i *StatusItem) Status() string {
+	if i.RemoteStack == nil || len(i.RemoteStack.Commits) == 0 {
+		return "new"
+	}
+	local, remote := i.LocalCommit, i.RemoteStack.Commits[0]
+	switch {
+	case local.Hash == remote.Hash:
+		return "unchanged"
+	case local.Tree == remote.Tree && local.Message == remote.Message:
+		return "rebased"
+	case local.Tree == remote.Tree:
+		return "reworded"
+	default:
+		return "changed"
+	}
 }
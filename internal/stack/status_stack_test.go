@@ -1,8 +1,12 @@
 package stack
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -11,7 +15,174 @@ func TestStatusStack(t *testing.T) {
 	t.Run("Load", func(t *testing.T) {
 		config := localRemoteRepo(t)
 		var statusStack StatusStack
-		require.NoError(t, statusStack.Load(config))
+		require.NoError(t, statusStack.Load(context.Background(), config))
 		fmt.Println(statusStack.String())
 	})
 }
+
+func TestStatusStackLoadPullRequests(t *testing.T) {
+	c := newTestContext("", Config{}.WithDefaults())
+	forge := &fakeForge{errs: map[string]error{"gh-stack-commit-c": errors.New("boom")}}
+	c.forge = forge
+	c.config.Concurrency = 2
+
+	var s StatusStack
+	for _, uid := range []string{"e", "d", "c", "", "b", "a"} {
+		commit := &GitCommit{UID: uid}
+		s.StatusItems = append(s.StatusItems, &StatusItem{UID: uid, LocalCommit: commit})
+		if uid != "" && uid != "d" {
+			require.NoError(t, forge.CreatePullRequest(context.Background(), &PullRequest{Head: commit.Branch()}))
+		}
+	}
+
+	require.NoError(t, s.LoadPullRequests(context.Background(), c))
+	for _, item := range s.StatusItems {
+		switch item.UID {
+		case "", "d":
+			require.Nil(t, item.PullRequest, item.UID)
+			require.NoError(t, item.Err, item.UID)
+		case "c":
+			require.Nil(t, item.PullRequest)
+			require.EqualError(t, item.Err, "boom")
+		default:
+			require.NoError(t, item.Err, item.UID)
+			require.Equal(t, "gh-stack-commit-"+item.UID, item.PullRequest.Head)
+			require.Equal(t, CISuccess, item.PullRequest.CI)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, s.LoadPullRequests(ctx, c), context.Canceled)
+
+	c.forge = nil
+	require.EqualError(t, s.LoadPullRequests(context.Background(), c), "no forge configured")
+}
+
+func TestStatusStackLoadPullRequestsBatch(t *testing.T) {
+	c := newTestContext("", Config{}.WithDefaults())
+	forge := &fakeBatchForge{}
+	c.forge = forge
+	require.NoError(t, forge.CreatePullRequest(context.Background(), &PullRequest{Head: "gh-stack-commit-a"}))
+
+	var s StatusStack
+	for _, uid := range []string{"b", "a"} {
+		s.StatusItems = append(s.StatusItems, &StatusItem{UID: uid, LocalCommit: &GitCommit{UID: uid}})
+	}
+	require.NoError(t, s.LoadPullRequests(context.Background(), c))
+	require.Equal(t, 1, forge.batches)
+	require.Nil(t, s.StatusItems[0].PullRequest)
+	require.Equal(t, "gh-stack-commit-a", s.StatusItems[1].PullRequest.Head)
+
+	// falls back to loading the items one by one
+	forge.err = errors.New("graphql unavailable")
+	s.StatusItems[1].PullRequest = nil
+	require.NoError(t, s.LoadPullRequests(context.Background(), c))
+	require.Equal(t, 2, forge.batches)
+	require.Equal(t, "gh-stack-commit-a", s.StatusItems[1].PullRequest.Head)
+	require.Equal(t, CISuccess, s.StatusItems[1].PullRequest.CI)
+
+	// cached lookups don't replace the single request
+	forge.err = nil
+	c.httpCache = &httpCache{dir: t.TempDir(), log: c.log}
+	for _, branch := range []string{"gh-stack-commit-a", "gh-stack-commit-b"} {
+		entry := &httpCacheEntry{URL: "https://api.github.com/repos/acme/widgets/pulls?head=acme%3A" + branch}
+		require.NoError(t, c.httpCache.write(filepath.Join(c.httpCache.dir, branch+".json"), entry))
+	}
+	require.NoError(t, s.LoadPullRequests(context.Background(), c))
+	require.Equal(t, 3, forge.batches)
+	require.Equal(t, "gh-stack-commit-a", s.StatusItems[1].PullRequest.Head)
+}
+
+// fakeBatchForge is a fakeForge that implements batchForge.
+type fakeBatchForge struct {
+	fakeForge
+	batches int
+	err     error
+}
+
+func (f *fakeBatchForge) FindPullRequests(ctx context.Context, heads []string) (map[string]*PullRequest, error) {
+	f.batches++
+	if f.err != nil {
+		return nil, f.err
+	}
+	prs := map[string]*PullRequest{}
+	for _, head := range heads {
+		if pr, err := f.FindPullRequest(ctx, head); err != nil {
+			return nil, err
+		} else if pr != nil {
+			prs[head] = pr
+		}
+	}
+	return prs, nil
+}
+
+func TestStatusStackOffline(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+	upstream := env
+	upstream.Dir = filepath.Join(env.Dir, "upstream")
+	require.NoError(t, env.RunMulti([]string{"mkdir", "-p", upstream.Dir}))
+	cmds := [][]string{{"git", "init"}}
+	cmds = append(cmds, createCommitCommands("A", "")...)
+	require.NoError(t, upstream.RunMulti(cmds...))
+
+	_, err := env.Run("git", "clone", "./upstream", "local")
+	require.NoError(t, err)
+	local := env
+	local.Dir = filepath.Join(env.Dir, "local")
+	cmds = createCommitCommands("B", "uid-b")
+	cmds = append(cmds, []string{"git", "push", "origin", "HEAD:refs/heads/gh-stack-commit-uid-b"})
+	cmds = append(cmds, createCommitCommands("C", "uid-c")...)
+	// fetching or contacting the forge would fail from here on
+	cmds = append(cmds, []string{"git", "remote", "set-url", "origin", "/does/not/exist"})
+	require.NoError(t, local.RunMulti(cmds...))
+
+	c, err := ContextOptions{Dir: local.Dir, LoadForge: true, Offline: true}.NewContext(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, c.forge)
+
+	fetchedAt := time.Now().Add(-time.Hour).Round(time.Second)
+	pr := &PullRequest{Number: 1, Head: "gh-stack-commit-uid-b", CI: CISuccess}
+	require.NoError(t, c.httpCache.SavePullRequests(map[string]*PullRequest{pr.Head: pr}, fetchedAt))
+	// saving the pull requests of another stack keeps those of this one
+	other := &PullRequest{Number: 2, Head: "gh-stack-commit-uid-x"}
+	require.NoError(t, c.httpCache.SavePullRequests(map[string]*PullRequest{other.Head: other}, fetchedAt.Add(30*time.Minute)))
+	cached, err := c.httpCache.LoadPullRequests()
+	require.NoError(t, err)
+	require.Len(t, cached, 2)
+	require.True(t, fetchedAt.Add(30*time.Minute).Equal(cached[other.Head].FetchedAt))
+
+	var s StatusStack
+	require.NoError(t, s.Load(context.Background(), c))
+	require.NoError(t, s.LoadPullRequests(context.Background(), c))
+	require.True(t, s.Offline)
+	require.True(t, fetchedAt.Equal(s.PullRequestsFetchedAt))
+	require.Equal(t, "new C\nunchanged B\n", s.String())
+	require.ErrorIs(t, s.StatusItems[0].Err, errNotCached)
+	require.NoError(t, s.StatusItems[1].Err)
+	require.Equal(t, pr, s.StatusItems[1].PullRequest)
+
+	// entries that weren't updated for a while are dropped
+	require.NoError(t, c.httpCache.SavePullRequests(map[string]*PullRequest{other.Head: other}, fetchedAt.Add(httpCacheMaxAge+time.Hour)))
+	cached, err = c.httpCache.LoadPullRequests()
+	require.NoError(t, err)
+	require.Len(t, cached, 1)
+	require.Contains(t, cached, other.Head)
+}
+
+func TestStatusItemStatus(t *testing.T) {
+	local := &GitCommit{Hash: "1", Tree: "t1", Message: "A"}
+	status := func(remote *GitCommit) string {
+		item := &StatusItem{LocalCommit: local}
+		if remote != nil {
+			item.RemoteStack = &RemoteStack{Commits: []*GitCommit{remote}}
+		}
+		return item.Status()
+	}
+	require.Equal(t, "new", status(nil))
+	require.Equal(t, "unchanged", status(&GitCommit{Hash: "1", Tree: "t1", Message: "A"}))
+	require.Equal(t, "rebased", status(&GitCommit{Hash: "2", Tree: "t1", Message: "A"}))
+	require.Equal(t, "reworded", status(&GitCommit{Hash: "2", Tree: "t1", Message: "B"}))
+	require.Equal(t, "changed", status(&GitCommit{Hash: "2", Tree: "t2", Message: "A"}))
+}
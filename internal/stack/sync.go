@@ -0,0 +1,307 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sync pushes every commit of the local stack to its branch on the push remote
+// and creates or updates a pull request for it on the remote repository. The
+// pull requests are returned in the order of the local stack. Commits without
+// a UID are assigned one first, see AssignUIDs.
+//
+// Each pull request targets the branch of the commit below it, so it only
+// shows the changes of its own commit. In the fork workflow the branches
+// only exist in the fork, and pull requests can't be based on them, so all
+// pull requests target RemoteHead instead, and their body names the commit
+// that is up for review as well as the pull request it depends on.
+//
+// The progress is recorded in the journal, see Operation. If a previous sync
+// was interrupted, Sync returns an *InterruptedError, and the sync has to be
+// finished with ResumeSync or rolled back with Undo first. Sync holds the lock
+// of the repository while it runs, so it fails with a *LockedError if another
+// process is syncing. If ctx is done, Sync stops after recording the steps it
+// completed, so it can be resumed.
+func Sync(ctx context.Context, c *Context) ([]*PullRequest, error) {
+	c = c.withContext(ctx)
+	lock, err := lockRepo(c)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	if err := checkSync(c); err != nil {
+		return nil, err
+	}
+
+	var ls LocalStack
+	if err := ls.Load(ctx, c); err != nil {
+		return nil, err
+	}
+	if len(ls.Commits) == 0 {
+		return nil, nil
+	}
+	op, err := beginOperation(c, "sync")
+	if err != nil {
+		return nil, fmt.Errorf("failed to record sync in the journal: %w", err)
+	}
+	return runSync(ctx, c, op, &ls)
+}
+
+// ResumeSync finishes the interrupted sync of the journal. The local stack
+// must not have changed since, otherwise the sync can only be undone.
+func ResumeSync(ctx context.Context, c *Context) ([]*PullRequest, error) {
+	c = c.withContext(ctx)
+	if c.forge == nil {
+		return nil, errors.New("sync requires a forge, enable LoadForge")
+	}
+	lock, err := lockRepo(c)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	op, err := LastOperation(c)
+	if err != nil {
+		return nil, err
+	} else if op == nil || op.Done || op.Name != "sync" {
+		return nil, errors.New("there is no interrupted sync to resume")
+	}
+	cur, err := c.cmd.Run("git", "rev-parse", "--verify", op.LocalRef+"^{commit}")
+	if err != nil {
+		return nil, err
+	} else if strings.TrimSpace(cur) != op.NewLocalHash {
+		return nil, fmt.Errorf("%s was changed since the interrupted sync, run `git stack undo` to roll it back instead", op.LocalRef)
+	}
+
+	var ls LocalStack
+	if err := ls.Load(ctx, c); err != nil {
+		return nil, err
+	}
+	c.log.Info("resuming interrupted sync", "started", op.Time)
+	return runSync(ctx, c, op, &ls)
+}
+
+// InterruptedError is returned by Sync if the last operation of the journal
+// did not complete.
+type InterruptedError struct {
+	Op *Operation
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("the %s started at %s was interrupted %s, "+
+		"run `git stack sync --continue` to finish it or `git stack undo` to roll it back",
+		e.Op.Name, e.Op.Time.Format("2006-01-02 15:04:05"), e.Op.Progress())
+}
+
+// checkSync returns an error if the context can't sync.
+func checkSync(c *Context) error {
+	if c.forge == nil {
+		return errors.New("sync requires a forge, enable LoadForge")
+	} else if c.config.IsFork() && (c.config.PushOwner == "" || c.config.PushRepo == "") {
+		return fmt.Errorf("failed to determine the repository of push remote %q, please configure push_owner and push_repo", c.config.PushRemoteName)
+	} else if _, ok := c.forge.(*githubForge); ok && c.config.IsFork() && strings.EqualFold(c.config.PushOwner, c.config.RemoteOwner) {
+		// github resolves the head owner:branch to the repository of the
+		// pull request itself then
+		return fmt.Errorf("push remote %q belongs to %s like the remote repository, github can't open pull requests from forks of the same owner", c.config.PushRemoteName, c.config.RemoteOwner)
+	}
+	return checkJournal(c)
+}
+
+// checkJournal returns an *InterruptedError if the last operation of the
+// journal didn't complete, as changing the local stack before it is finished
+// or undone would get in the way of both.
+func checkJournal(c *Context) error {
+	if op, err := LastOperation(c); err != nil {
+		return err
+	} else if op != nil && !op.Done {
+		return &InterruptedError{Op: op}
+	}
+	return nil
+}
+
+// runSync performs the steps of sync that op hasn't completed yet, recording
+// each step in op before performing it.
+func runSync(ctx context.Context, c *Context, op *Operation, ls *LocalStack) ([]*PullRequest, error) {
+	if n, err := AssignUIDs(c, ls); err != nil {
+		return nil, fmt.Errorf("failed to assign commit UIDs: %w", err)
+	} else if n > 0 {
+		c.log.Info("assigned commit UIDs", "commits", n)
+		op.NewLocalHash = ls.Commits[0].Hash
+		if err := op.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !op.Pushed {
+		if err := pushBranches(c, op, ls); err != nil {
+			return nil, err
+		}
+	}
+
+	prs := make([]*PullRequest, len(ls.Commits))
+	var prev *PullRequest
+	for i := len(ls.Commits) - 1; i >= 0; i-- {
+		base := c.config.RemoteHead
+		if i+1 < len(ls.Commits) && !c.config.IsFork() {
+			base = ls.Commits[i+1].Branch()
+		}
+		pr, err := syncPullRequest(ctx, c, op, ls.Commits[i], base, prev)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ls.Commits[i].Oneline(), err)
+		}
+		prs[i] = pr
+		prev = pr
+	}
+
+	op.Done = true
+	if err := op.save(); err != nil {
+		return nil, err
+	}
+	return prs, op.removeIfNoop()
+}
+
+// pushBranches pushes every commit of the local stack to its branch on the
+// push remote.
+//
+// The values of the branches on the remote are recorded in op before the
+// push, which is atomic and leases the branches at those values, so it never
+// overwrites a push by someone else. A resumed sync keeps the recorded values
+// and skips the branches that were pushed already.
+func pushBranches(c *Context, op *Operation, ls *LocalStack) error {
+	remote := c.config.PushRemoteName
+	current, err := lsRemoteBranches(c, remote)
+	if err != nil {
+		return err
+	}
+	if op.Branches == nil {
+		if err := recordBranches(c, op, ls, current); err != nil {
+			return err
+		}
+	}
+
+	push := []string{"git", "push", "--atomic", "--progress", remote}
+	var refspecs []string
+	for _, b := range op.Branches {
+		if b.Old == b.New {
+			continue
+		}
+		switch current[b.Branch] {
+		case b.New:
+			// pushed before the sync was interrupted
+			continue
+		case b.Old:
+		default:
+			return fmt.Errorf("branches on %s were changed by someone else, fetch and check them before syncing again", remote)
+		}
+		ref := "refs/heads/" + b.Branch
+		// an empty lease requires the branch to not exist yet
+		push = append(push, "--force-with-lease="+ref+":"+b.Old)
+		refspecs = append(refspecs, b.New+":"+ref)
+	}
+	if len(refspecs) > 0 {
+		if _, err := c.cmd.RunLogged(append(push, refspecs...)...); IsAuthFailure(err) {
+			return fmt.Errorf("failed to push to %s, check your git credentials: %w", remote, err)
+		} else if IsNonFastForward(err) {
+			return fmt.Errorf("branches on %s were changed by someone else, fetch and check them before syncing again: %w", remote, err)
+		} else if err != nil {
+			return err
+		}
+	}
+	op.Pushed = true
+	if err := op.save(); err != nil {
+		return err
+	}
+
+	// the cached forge responses of the branches whose head changed are
+	// stale now
+	for _, b := range op.Branches {
+		if b.Old == b.New {
+			continue
+		} else if err := c.httpCache.Invalidate(b.Branch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordBranches records the branches pushBranches updates in op, with the
+// values they currently have on the push remote as Old. It fails if a branch
+// differs from its remote-tracking branch, unless it already has the value to
+// push, as someone else changed it since the last fetch.
+func recordBranches(c *Context, op *Operation, ls *LocalStack, current map[string]string) error {
+	remote := c.config.PushRemoteName
+	tracking, err := remoteBranchTips(c, remote)
+	if err != nil {
+		return err
+	}
+	for _, commit := range ls.Commits {
+		old := current[commit.Branch()]
+		if old != tracking[commit.Branch()] && old != commit.Hash {
+			return fmt.Errorf("branches on %s were changed by someone else, fetch and check them before syncing again", remote)
+		}
+		op.Branches = append(op.Branches, BranchUpdate{Branch: commit.Branch(), Old: old, New: commit.Hash})
+	}
+	return op.save()
+}
+
+// syncPullRequest creates or updates the pull request of the given commit
+// against base and records the change in op. prev is the pull request of the
+// commit below it, or nil.
+func syncPullRequest(ctx context.Context, c *Context, op *Operation, commit *GitCommit, base string, prev *PullRequest) (*PullRequest, error) {
+	want := &PullRequest{
+		Title: commit.Oneline(),
+		Body:  pullRequestBody(c, commit, prev),
+		Head:  c.config.PullRequestHead(commit.Branch()),
+		Base:  base,
+	}
+
+	pr, err := c.forge.FindPullRequest(ctx, want.Head)
+	if err != nil {
+		return nil, err
+	} else if pr == nil {
+		op.PullRequests = append(op.PullRequests, PullRequestUpdate{New: want})
+		if err := op.save(); err != nil {
+			return nil, err
+		} else if err := c.forge.CreatePullRequest(ctx, want); err != nil {
+			return nil, err
+		} else if err := op.save(); err != nil {
+			// records the number and url of the new pull request
+			return nil, err
+		}
+		c.log.Debug("created pull request", "head", want.Head, "base", want.Base, "url", want.URL)
+		return want, nil
+	}
+
+	want.Number, want.URL = pr.Number, pr.URL
+	if pr.Title == want.Title && pr.Body == want.Body && pr.Base == want.Base {
+		return want, nil
+	}
+	op.PullRequests = append(op.PullRequests, PullRequestUpdate{Old: pr, New: want})
+	if err := op.save(); err != nil {
+		return nil, err
+	} else if err := c.forge.UpdatePullRequest(ctx, want); err != nil {
+		return nil, err
+	}
+	c.log.Debug("updated pull request", "head", want.Head, "base", want.Base, "url", want.URL)
+	return want, nil
+}
+
+// pullRequestBody returns the commit message without its subject line. In the
+// fork workflow it is followed by a note that names the commit that is up for
+// review, as the pull request is based on RemoteHead and also shows the
+// commits below it, which are reviewed in prev.
+func pullRequestBody(c *Context, commit *GitCommit, prev *PullRequest) string {
+	body := commit.Message
+	if i := strings.Index(body, "\n"); i >= 0 {
+		body = strings.TrimSpace(body[i:])
+	} else {
+		body = ""
+	}
+	if !c.config.IsFork() || prev == nil {
+		return body
+	}
+	url := c.forge.CommitURL(c.config.PushOwner, c.config.PushRepo, commit.Hash)
+	return body + fmt.Sprintf("\n\n---\n\nThis pull request is part of a stack. It also shows the commits below it, "+
+		"which are reviewed in %s. Only review its last commit, %q: %s", prev.URL, commit.Oneline(), url)
+}
@@ -0,0 +1,411 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+
+	upstream := env
+	upstream.Dir = filepath.Join(env.Dir, "upstream")
+	require.NoError(t, env.RunMulti([]string{"mkdir", "-p", upstream.Dir}))
+	cmds := [][]string{{"git", "init"}}
+	cmds = append(cmds, createCommitCommands("A", "")...)
+	require.NoError(t, upstream.RunMulti(cmds...))
+
+	local := env
+	local.Dir = filepath.Join(env.Dir, "local")
+	require.NoError(t, env.RunMulti(
+		[]string{"git", "clone", "--bare", "./upstream", "fork.git"},
+		[]string{"git", "clone", "./upstream", "local"},
+	))
+	// seeded uids make the branch names predictable
+	uids := newSeededUIDGenerator(1)
+	uidB, err := uids.NewUID()
+	require.NoError(t, err)
+	uidC, err := uids.NewUID()
+	require.NoError(t, err)
+	cmds = [][]string{{"git", "remote", "add", "fork", "../fork.git"}}
+	cmds = append(cmds, createCommitCommands("B", uidB)...)
+	cmds = append(cmds, createCommitCommands("C", uidC)...)
+	require.NoError(t, local.RunMulti(cmds...))
+
+	newContext := func(t *testing.T, config Config) (*Context, *fakeForge) {
+		t.Helper()
+		c, err := ContextOptions{Dir: local.Dir}.NewContext(context.Background())
+		require.NoError(t, err)
+		c.config.RemoteOwner, c.config.RemoteRepo = "acme", "widgets"
+		c.config.PushOwner, c.config.PushRepo = "acme", "widgets"
+		if config.PushRemoteName != "" {
+			c.config.PushRemoteName = config.PushRemoteName
+			c.config.PushOwner, c.config.PushRepo = config.PushOwner, config.PushRepo
+		}
+		forge := &fakeForge{}
+		c.forge = forge
+		return c, forge
+	}
+
+	t.Run("stacked", func(t *testing.T) {
+		c, forge := newContext(t, Config{})
+		prs, err := Sync(context.Background(), c)
+		require.NoError(t, err)
+		require.Len(t, prs, 2)
+
+		require.Equal(t, "C", prs[0].Title)
+		require.Equal(t, "gh-stack-commit-78629a0f5f3f164f", prs[0].Head)
+		require.Equal(t, "gh-stack-commit-4d65822107fcfd52", prs[0].Base)
+		require.Equal(t, "This is commit: C\nCommit-UID: 78629a0f5f3f164f", prs[0].Body)
+		require.Equal(t, "B", prs[1].Title)
+		require.Equal(t, "gh-stack-commit-4d65822107fcfd52", prs[1].Head)
+		require.Equal(t, c.config.RemoteHead, prs[1].Base)
+
+		head, err := upstream.Run("git", "rev-parse", "gh-stack-commit-78629a0f5f3f164f")
+		require.NoError(t, err)
+		localHead, err := local.Run("git", "rev-parse", "HEAD")
+		require.NoError(t, err)
+		require.Equal(t, localHead, head)
+
+		prs2, err := Sync(context.Background(), c)
+		require.NoError(t, err)
+		require.Equal(t, prs, prs2)
+		require.Len(t, forge.prs, 2)
+		require.Equal(t, 0, forge.updates)
+	})
+
+	t.Run("fork", func(t *testing.T) {
+		c, forge := newContext(t, Config{PushRemoteName: "fork", PushOwner: "me", PushRepo: "widgets"})
+		prs, err := Sync(context.Background(), c)
+		require.NoError(t, err)
+		require.Len(t, prs, 2)
+
+		// the branches only exist in the fork, so C is based on the remote
+		// head and its body says which commit to review
+		head, err := local.Run("git", "rev-parse", "HEAD")
+		require.NoError(t, err)
+		require.Equal(t, "me:gh-stack-commit-78629a0f5f3f164f", prs[0].Head)
+		require.Equal(t, c.config.RemoteHead, prs[0].Base)
+		require.Equal(t, "This is commit: C\nCommit-UID: 78629a0f5f3f164f\n\n---\n\n"+
+			"This pull request is part of a stack. It also shows the commits below it, which are reviewed in "+prs[1].URL+". "+
+			"Only review its last commit, \"C\": "+forge.CommitURL("me", "widgets", strings.TrimSpace(head)), prs[0].Body)
+		require.Equal(t, "me:gh-stack-commit-4d65822107fcfd52", prs[1].Head)
+		require.Equal(t, c.config.RemoteHead, prs[1].Base)
+		require.Len(t, forge.prs, 2)
+
+		for _, branch := range []string{"gh-stack-commit-4d65822107fcfd52", "gh-stack-commit-78629a0f5f3f164f"} {
+			_, err = env.Run("git", "--git-dir", "fork.git", "rev-parse", branch)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("fork without push access to the remote", func(t *testing.T) {
+		hook := filepath.Join(upstream.Dir, ".git", "hooks", "pre-receive")
+		require.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\necho 'Permission to acme/widgets.git denied to me.' >&2\nexit 1\n"), 0755))
+		t.Cleanup(func() { os.Remove(hook) })
+		cmds := createCommitCommands("D", "uid-d")
+		cmds = append(cmds, createCommitCommands("E", "uid-e")...)
+		require.NoError(t, local.RunMulti(cmds...))
+		t.Cleanup(func() {
+			_, err := local.Run("git", "reset", "--hard", "HEAD~2")
+			require.NoError(t, err)
+		})
+
+		c, forge := newContext(t, Config{PushRemoteName: "fork", PushOwner: "me", PushRepo: "widgets"})
+		prs, err := Sync(context.Background(), c)
+		require.NoError(t, err)
+		require.Len(t, prs, 4)
+		require.Len(t, forge.prs, 4)
+		_, err = env.Run("git", "--git-dir", "fork.git", "rev-parse", "--verify", "gh-stack-commit-uid-e")
+		require.NoError(t, err)
+		_, err = upstream.Run("git", "rev-parse", "--verify", "gh-stack-commit-uid-d")
+		require.Error(t, err)
+	})
+
+	t.Run("fork of the same owner", func(t *testing.T) {
+		c, _ := newContext(t, Config{PushRemoteName: "fork", PushOwner: "acme", PushRepo: "widgets-fork"})
+		c.forge = &githubForge{}
+		before, err := LastOperation(c)
+		require.NoError(t, err)
+		_, err = Sync(context.Background(), c)
+		require.EqualError(t, err, `push remote "fork" belongs to acme like the remote repository, github can't open pull requests from forks of the same owner`)
+		// nothing was recorded in the journal
+		after, err := LastOperation(c)
+		require.NoError(t, err)
+		require.Equal(t, before, after)
+	})
+
+	t.Run("stale tracking branch", func(t *testing.T) {
+		// C was reworded and pushed elsewhere, which the tracking branch
+		// doesn't know yet
+		const branch = "gh-stack-commit-78629a0f5f3f164f"
+		stale, err := local.Run("git", "rev-parse", "refs/remotes/origin/"+branch)
+		require.NoError(t, err)
+		require.NoError(t, local.RunMulti(
+			[]string{"git", "commit", "--amend", "-m", "C\n\nPushed elsewhere\n\nCommit-UID: 78629a0f5f3f164f"},
+			[]string{"git", "push", "origin", "+HEAD:refs/heads/" + branch},
+			[]string{"git", "update-ref", "refs/remotes/origin/" + branch, strings.TrimSpace(stale)},
+		))
+		head, err := local.Run("git", "rev-parse", "HEAD")
+		require.NoError(t, err)
+
+		c, _ := newContext(t, Config{})
+		_, err = Sync(context.Background(), c)
+		require.NoError(t, err)
+		op, err := LastOperation(c)
+		require.NoError(t, err)
+		// the recorded value is the one of the remote, so undo leaves it
+		require.Contains(t, op.Branches, BranchUpdate{Branch: branch, Old: strings.TrimSpace(head), New: strings.TrimSpace(head)})
+	})
+
+	t.Run("lease", func(t *testing.T) {
+		// someone else pushed to the branch of C since it was fetched
+		_, err := upstream.Run("git", "branch", "-f", "gh-stack-commit-78629a0f5f3f164f", "HEAD")
+		require.NoError(t, err)
+		require.NoError(t, local.RunMulti(
+			[]string{"git", "commit", "--amend", "-m", "C\n\nReworded\n\nCommit-UID: 78629a0f5f3f164f"},
+		))
+		c, _ := newContext(t, Config{})
+		_, err = Sync(context.Background(), c)
+		require.ErrorContains(t, err, "branches on origin were changed by someone else")
+	})
+}
+
+func TestResumeSync(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+
+	upstream := env
+	upstream.Dir = filepath.Join(env.Dir, "upstream")
+	require.NoError(t, env.RunMulti([]string{"mkdir", "-p", upstream.Dir}))
+	cmds := [][]string{{"git", "init"}}
+	cmds = append(cmds, createCommitCommands("A", "")...)
+	require.NoError(t, upstream.RunMulti(cmds...))
+	_, err := env.Run("git", "clone", "./upstream", "local")
+	require.NoError(t, err)
+	local := env
+	local.Dir = filepath.Join(env.Dir, "local")
+	cmds = createCommitCommands("B", "uid-b")
+	cmds = append(cmds, createCommitCommands("C", "uid-c")...)
+	require.NoError(t, local.RunMulti(cmds...))
+
+	forge := &fakeForge{}
+	newContext := func(t *testing.T) *Context {
+		t.Helper()
+		c, err := ContextOptions{Dir: local.Dir}.NewContext(context.Background())
+		require.NoError(t, err)
+		c.config.RemoteOwner, c.config.RemoteRepo = "acme", "widgets"
+		c.config.PushOwner, c.config.PushRepo = "acme", "widgets"
+		c.forge = forge
+		return c
+	}
+
+	// interrupt the sync after the pull request of B was created
+	forge.errs = map[string]error{"gh-stack-commit-uid-c": errors.New("connection reset")}
+	_, err = Sync(context.Background(), newContext(t))
+	require.ErrorContains(t, err, "connection reset")
+	require.Len(t, forge.prs, 1)
+	_, err = upstream.Run("git", "rev-parse", "gh-stack-commit-uid-c")
+	require.NoError(t, err)
+
+	var interrupted *InterruptedError
+	_, err = Sync(context.Background(), newContext(t))
+	require.ErrorAs(t, err, &interrupted)
+	require.Equal(t, "after pushing its branches and creating or updating 1 pull request", interrupted.Op.Progress())
+	var ss StatusStack
+	c := newContext(t)
+	c.offline = true
+	require.NoError(t, ss.Load(context.Background(), c))
+	require.NotNil(t, ss.Interrupted)
+
+	forge.errs = nil
+	prs, err := ResumeSync(context.Background(), newContext(t))
+	require.NoError(t, err)
+	require.Len(t, prs, 2)
+	require.Len(t, forge.prs, 2)
+	require.Equal(t, prs[1].Head, prs[0].Base)
+	_, err = ResumeSync(context.Background(), newContext(t))
+	require.EqualError(t, err, "there is no interrupted sync to resume")
+
+	// undo rolls back the whole sync, not only the part before the
+	// interruption
+	op, _, err := Undo(context.Background(), newContext(t))
+	require.NoError(t, err)
+	require.True(t, op.Done)
+	require.Len(t, op.PullRequests, 2)
+
+	t.Run("changed", func(t *testing.T) {
+		forge.errs = map[string]error{"gh-stack-commit-uid-c": errors.New("connection reset")}
+		_, err := Sync(context.Background(), newContext(t))
+		require.Error(t, err)
+		require.NoError(t, local.RunMulti(createCommitCommands("D", "uid-d")...))
+
+		forge.errs = nil
+		_, err = ResumeSync(context.Background(), newContext(t))
+		require.ErrorContains(t, err, "refs/heads/main was changed since the interrupted sync")
+		_, _, err = Undo(context.Background(), newContext(t))
+		require.NoError(t, err)
+		_, err = Sync(context.Background(), newContext(t))
+		require.NoError(t, err)
+	})
+
+	t.Run("interrupted push", func(t *testing.T) {
+		rev := func(t *testing.T) string {
+			t.Helper()
+			out, err := upstream.Run("git", "rev-parse", "gh-stack-commit-uid-d")
+			require.NoError(t, err)
+			return strings.TrimSpace(out)
+		}
+		oldD := rev(t)
+		interrupt := func(t *testing.T) {
+			t.Helper()
+			forge.errs = map[string]error{"gh-stack-commit-uid-d": errors.New("connection reset")}
+			_, err := Sync(context.Background(), newContext(t))
+			require.ErrorContains(t, err, "connection reset")
+			forge.errs = nil
+			require.NotEqual(t, oldD, rev(t))
+			// as if git pushed the branches, but sync was interrupted before
+			// recording it
+			op, err := LastOperation(newContext(t))
+			require.NoError(t, err)
+			op.Pushed = false
+			require.NoError(t, op.save())
+			require.Equal(t, "before it finished pushing its branches", op.Progress())
+		}
+		require.NoError(t, local.RunMulti(
+			[]string{"git", "commit", "--amend", "-m", "D\n\nReworded\n\nCommit-UID: uid-d"},
+		))
+
+		// undo restores the pushed branches
+		interrupt(t)
+		_, _, err := Undo(context.Background(), newContext(t))
+		require.NoError(t, err)
+		require.Equal(t, oldD, rev(t))
+
+		// resume keeps the recorded values of the branches, so undo still
+		// restores them afterwards
+		interrupt(t)
+		_, err = ResumeSync(context.Background(), newContext(t))
+		require.NoError(t, err)
+		op, notes, err := Undo(context.Background(), newContext(t))
+		require.NoError(t, err)
+		require.Empty(t, notes)
+		require.Equal(t, "gh-stack-commit-uid-d", op.Branches[0].Branch)
+		require.Equal(t, oldD, op.Branches[0].Old)
+		require.Equal(t, oldD, rev(t))
+	})
+}
+
+func TestSyncSeededUIDs(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+
+	upstream := env
+	upstream.Dir = filepath.Join(env.Dir, "upstream")
+	require.NoError(t, env.RunMulti([]string{"mkdir", "-p", upstream.Dir}))
+	cmds := [][]string{{"git", "init"}}
+	cmds = append(cmds, createCommitCommands("A", "")...)
+	require.NoError(t, upstream.RunMulti(cmds...))
+	_, err := env.Run("git", "clone", "./upstream", "local")
+	require.NoError(t, err)
+	local := env
+	local.Dir = filepath.Join(env.Dir, "local")
+
+	forge := &fakeForge{}
+	// every sync runs in a new process, which starts the seeded sequence
+	// again
+	sync := func(t *testing.T) []*PullRequest {
+		t.Helper()
+		c, err := ContextOptions{Dir: local.Dir}.NewContext(context.Background())
+		require.NoError(t, err)
+		c.config.RemoteOwner, c.config.RemoteRepo = "acme", "widgets"
+		c.config.PushOwner, c.config.PushRepo = "acme", "widgets"
+		c.forge = forge
+		c.uids = newSeededUIDGenerator(1)
+		prs, err := Sync(context.Background(), c)
+		require.NoError(t, err)
+		return prs
+	}
+
+	require.NoError(t, local.RunMulti(createCommitCommands("B", "")...))
+	prs := sync(t)
+	require.Len(t, prs, 1)
+	require.Equal(t, "gh-stack-commit-4d65822107fcfd52", prs[0].Head)
+
+	require.NoError(t, local.RunMulti(createCommitCommands("C", "")...))
+	prs = sync(t)
+	require.Len(t, prs, 2)
+	require.Equal(t, "gh-stack-commit-4d65822107fcfd52", prs[1].Head)
+	require.NotEqual(t, prs[1].Head, prs[0].Head)
+	require.Len(t, forge.prs, 2)
+	for i, name := range []string{"C", "B"} {
+		out, err := upstream.Run("git", "log", "-1", "--format=%s", prs[i].Head)
+		require.NoError(t, err)
+		require.Equal(t, name+"\n", out)
+	}
+}
+
+// fakeForge is an in-memory Forge.
+type fakeForge struct {
+	mu      sync.Mutex
+	prs     []*PullRequest
+	updates int
+	// errs are returned by FindPullRequest for the given heads.
+	errs map[string]error
+}
+
+func (f *fakeForge) FindPullRequest(_ context.Context, head string) (*PullRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs[head]; err != nil {
+		return nil, err
+	}
+	for _, pr := range f.prs {
+		if pr.Head == head {
+			cp := *pr
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeForge) CreatePullRequest(_ context.Context, pr *PullRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pr.Number = len(f.prs) + 1
+	pr.URL = fmt.Sprintf("https://example.com/pull/%d", pr.Number)
+	cp := *pr
+	f.prs = append(f.prs, &cp)
+	return nil
+}
+
+func (f *fakeForge) UpdatePullRequest(_ context.Context, pr *PullRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates++
+	cp := *pr
+	f.prs[pr.Number-1] = &cp
+	return nil
+}
+
+func (f *fakeForge) LoadStatus(_ context.Context, pr *PullRequest) error {
+	pr.CI, pr.Review = CISuccess, ReviewApproved
+	return nil
+}
+
+func (f *fakeForge) DefaultBranch(_ context.Context) (string, error) {
+	return "main", nil
+}
+
+func (f *fakeForge) CommitURL(owner, repo, hash string) string {
+	return "https://example.com/" + strings.Join([]string{owner, repo, "commit", hash}, "/")
+}
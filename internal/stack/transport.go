@@ -0,0 +1,202 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// newForgeHTTPClient returns the http client used for the forge api, which
+// sends requests via base, caches their responses in the httpCache of the
+// context and retries them with retryTransport. Requests including their
+// retries time out after the configured Timeout.
+func newForgeHTTPClient(c *Context, base http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: newRetryTransport(c.httpCache.transport(base), c.log),
+		Timeout:   c.config.Timeout,
+	}
+}
+
+// RateLimitError is returned for requests rejected by the rate limit of the
+// forge api if the limit doesn't reset soon enough to wait for it.
+type RateLimitError struct {
+	// Reset is when the limit resets.
+	Reset time.Time
+	// Secondary is true for the secondary (abuse) limits of github, which
+	// apply to bursts of requests rather than to the hourly quota.
+	Secondary bool
+}
+
+func (e *RateLimitError) Error() string {
+	kind := "rate limit"
+	if e.Secondary {
+		kind = "secondary rate limit"
+	}
+	return fmt.Sprintf("%s exceeded, resets at %s (in %s)", kind, e.Reset.Format(time.Kitchen), time.Until(e.Reset).Round(time.Second))
+}
+
+// retryTransport is an http.RoundTripper that waits for rate limits to reset
+// and retries idempotent requests that failed with a server error.
+type retryTransport struct {
+	base http.RoundTripper
+	log  *slog.Logger
+	// maxRetries is the maximum number of retries of a request.
+	maxRetries int
+	// backoff is the delay before the first retry of a server error, it
+	// doubles with every retry and is jittered by ±50%.
+	backoff time.Duration
+	// maxWait is the longest time to wait for a rate limit to reset, longer
+	// waits fail with a *RateLimitError instead.
+	maxWait time.Duration
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+	// jitter returns a random number in [0, 1).
+	jitter func() float64
+}
+
+func newRetryTransport(base http.RoundTripper, log *slog.Logger) *retryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{
+		base:       base,
+		log:        log,
+		maxRetries: 3,
+		backoff:    500 * time.Millisecond,
+		maxWait:    time.Minute,
+		now:        time.Now,
+		sleep:      sleepContext,
+		jitter:     rand.Float64,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := t.base.RoundTrip(req)
+		wait, retry, err := t.check(req, res, err, attempt)
+		if !retry && err != nil && res != nil {
+			res.Body.Close()
+			return nil, err
+		} else if !retry {
+			return res, err
+		}
+		if res != nil {
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+			res.Body.Close()
+		}
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("can't retry request with a body that can't be rewound: %w", err)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		t.log.Debug("retrying forge request", "method", req.Method, "url", req.URL.String(), "attempt", attempt+1, "wait", wait, "reason", err)
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// check returns whether the request should be retried after waiting for the
+// returned duration. If not, it returns the response and error to return.
+func (t *retryTransport) check(req *http.Request, res *http.Response, err error, attempt int) (time.Duration, bool, error) {
+	if attempt >= t.maxRetries {
+		if res != nil {
+			if limitErr := t.rateLimitError(res); limitErr != nil {
+				return 0, false, limitErr
+			}
+		}
+		return 0, false, err
+	} else if err != nil {
+		// the request may or may not have reached the server
+		return t.backoffDelay(attempt), isIdempotent(req) && req.Context().Err() == nil, err
+	}
+
+	if limitErr := t.rateLimitError(res); limitErr != nil {
+		// rejected requests were not processed, so any method can be retried
+		wait := limitErr.Reset.Sub(t.now())
+		if wait < 0 {
+			wait = 0
+		}
+		if wait > t.maxWait {
+			return 0, false, limitErr
+		}
+		return wait, true, limitErr
+	}
+	switch res.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if isIdempotent(req) {
+			return t.backoffDelay(attempt), true, fmt.Errorf("server error: %s", res.Status)
+		}
+	}
+	return 0, false, nil
+}
+
+// rateLimitError returns the rate limit the response was rejected by, or nil
+// if it wasn't rejected by a rate limit.
+func (t *retryTransport) rateLimitError(res *http.Response) *RateLimitError {
+	if res.StatusCode != http.StatusForbidden && res.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	if s := res.Header.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil {
+			return &RateLimitError{Reset: t.now().Add(time.Duration(secs) * time.Second), Secondary: true}
+		} else if date, err := http.ParseTime(s); err == nil {
+			return &RateLimitError{Reset: date, Secondary: true}
+		}
+	}
+	// github and gitea use X-RateLimit-*, gitlab RateLimit-*
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+		if res.Header.Get(prefix+"Remaining") != "0" {
+			continue
+		}
+		if reset, err := strconv.ParseInt(res.Header.Get(prefix+"Reset"), 10, 64); err == nil {
+			return &RateLimitError{Reset: time.Unix(reset, 0)}
+		}
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		// no hint when the limit resets, so back off like for server errors
+		return &RateLimitError{Reset: t.now().Add(t.backoff), Secondary: true}
+	}
+	return nil
+}
+
+func (t *retryTransport) backoffDelay(attempt int) time.Duration {
+	d := t.backoff << attempt
+	return d/2 + time.Duration(t.jitter()*float64(d))
+}
+
+// isIdempotent returns true if repeating the request has the same effect as
+// sending it once.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
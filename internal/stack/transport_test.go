@@ -0,0 +1,131 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+)
+
+// roundTripFunc is an http.RoundTripper implemented by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryTransport(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	newTransport := func(responses ...func(*http.Request) (*http.Response, error)) (*retryTransport, *[]time.Duration, *[]string) {
+		var waits []time.Duration
+		var bodies []string
+		base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Body != nil {
+				data, err := io.ReadAll(req.Body)
+				require.NoError(t, err)
+				bodies = append(bodies, string(data))
+			}
+			next := responses[0]
+			responses = responses[1:]
+			return next(req)
+		})
+		tr := newRetryTransport(base, slog.New(slog.HandlerOptions{}.NewTextHandler(io.Discard)))
+		tr.now = func() time.Time { return now }
+		tr.jitter = func() float64 { return 0.5 }
+		tr.sleep = func(_ context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		}
+		return tr, &waits, &bodies
+	}
+	respond := func(status int, header ...string) func(*http.Request) (*http.Response, error) {
+		return func(*http.Request) (*http.Response, error) {
+			res := &http.Response{StatusCode: status, Status: strconv.Itoa(status), Header: http.Header{}, Body: io.NopCloser(strings.NewReader("body"))}
+			for i := 0; i < len(header); i += 2 {
+				res.Header.Set(header[i], header[i+1])
+			}
+			return res, nil
+		}
+	}
+	do := func(t *testing.T, tr http.RoundTripper, method string) (*http.Response, error) {
+		t.Helper()
+		req, err := http.NewRequest(method, "https://api.example.com/x", strings.NewReader("payload"))
+		require.NoError(t, err)
+		return tr.RoundTrip(req)
+	}
+
+	t.Run("server errors", func(t *testing.T) {
+		tr, waits, bodies := newTransport(respond(502), respond(503), respond(200))
+		res, err := do(t, tr, http.MethodGet)
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		require.Equal(t, []time.Duration{500 * time.Millisecond, time.Second}, *waits)
+		require.Equal(t, []string{"payload", "payload", "payload"}, *bodies)
+
+		tr, waits, _ = newTransport(respond(500), respond(500), respond(500), respond(500))
+		res, err = do(t, tr, http.MethodGet)
+		require.NoError(t, err)
+		require.Equal(t, 500, res.StatusCode)
+		require.Len(t, *waits, 3)
+	})
+
+	t.Run("non-idempotent", func(t *testing.T) {
+		tr, waits, _ := newTransport(respond(502))
+		res, err := do(t, tr, http.MethodPost)
+		require.NoError(t, err)
+		require.Equal(t, 502, res.StatusCode)
+		require.Empty(t, *waits)
+
+		tr, _, _ = newTransport(func(*http.Request) (*http.Response, error) { return nil, errors.New("connection reset") })
+		_, err = do(t, tr, http.MethodPost)
+		require.EqualError(t, err, "connection reset")
+	})
+
+	t.Run("secondary rate limit", func(t *testing.T) {
+		tr, waits, _ := newTransport(respond(403, "Retry-After", "30"), respond(201))
+		res, err := do(t, tr, http.MethodPost)
+		require.NoError(t, err)
+		require.Equal(t, 201, res.StatusCode)
+		require.Equal(t, []time.Duration{30 * time.Second}, *waits)
+
+		tr, _, _ = newTransport(respond(429, "Retry-After", "120"))
+		_, err = do(t, tr, http.MethodGet)
+		var limitErr *RateLimitError
+		require.ErrorAs(t, err, &limitErr)
+		require.True(t, limitErr.Secondary)
+		require.Equal(t, now.Add(2*time.Minute), limitErr.Reset)
+	})
+
+	t.Run("primary rate limit", func(t *testing.T) {
+		reset := strconv.FormatInt(now.Add(10*time.Second).Unix(), 10)
+		tr, waits, _ := newTransport(respond(403, "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", reset), respond(200))
+		res, err := do(t, tr, http.MethodGet)
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		require.Equal(t, []time.Duration{10 * time.Second}, *waits)
+
+		reset = strconv.FormatInt(now.Add(time.Hour).Unix(), 10)
+		tr, waits, _ = newTransport(respond(429, "RateLimit-Remaining", "0", "RateLimit-Reset", reset))
+		_, err = do(t, tr, http.MethodGet)
+		var limitErr *RateLimitError
+		require.ErrorAs(t, err, &limitErr)
+		require.False(t, limitErr.Secondary)
+		require.Equal(t, now.Add(time.Hour), limitErr.Reset)
+		require.Contains(t, err.Error(), "rate limit exceeded, resets at ")
+		require.Empty(t, *waits)
+
+		// a forbidden response that is not rate limited is passed through
+		tr, waits, _ = newTransport(respond(403, "X-RateLimit-Remaining", "4999"))
+		res, err = do(t, tr, http.MethodGet)
+		require.NoError(t, err)
+		require.Equal(t, 403, res.StatusCode)
+		require.Empty(t, *waits)
+	})
+}
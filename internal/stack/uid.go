@@ -0,0 +1,138 @@
+package stack
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UIDGenerator generates the values of Commit-UID trailers.
+type UIDGenerator interface {
+	NewUID() (string, error)
+}
+
+// NewUIDGenerator returns the generator for the given spec, which is one of:
+//
+//   - "random": 16 random hex characters, the default.
+//   - "ulid": a lowercase ULID, which sorts in creation order.
+//   - "seeded" or "seeded:<n>": a deterministic sequence of 16 hex characters
+//     seeded with n (default 1), for tests and reproducible demos.
+func NewUIDGenerator(spec string) (UIDGenerator, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+	switch {
+	case (name == "random" || name == "") && !hasArg:
+		return randomUIDGenerator{}, nil
+	case name == "ulid" && !hasArg:
+		return &ulidGenerator{now: time.Now, entropy: rand.Reader}, nil
+	case name == "seeded":
+		seed := int64(1)
+		if hasArg {
+			var err error
+			if seed, err = strconv.ParseInt(arg, 10, 64); err != nil {
+				return nil, fmt.Errorf("bad uid generator seed: %q", arg)
+			}
+		}
+		return newSeededUIDGenerator(seed), nil
+	default:
+		return nil, fmt.Errorf("unknown uid generator: %q", spec)
+	}
+}
+
+// formatUID returns the generated uid in the form expected for a trailer with
+// the given key. Gerrit rejects Change-Ids that aren't an I followed by 40 hex
+// characters, so those are derived from the SHA-1 of the uid.
+func formatUID(key, uid string) string {
+	if !strings.EqualFold(key, "Change-Id") {
+		return uid
+	}
+	sum := sha1.Sum([]byte(uid))
+	return "I" + hex.EncodeToString(sum[:])
+}
+
+// randomUIDGenerator generates random hex UIDs.
+type randomUIDGenerator struct{}
+
+func (randomUIDGenerator) NewUID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// seededUIDGenerator generates a deterministic sequence of hex UIDs.
+type seededUIDGenerator struct {
+	mu  sync.Mutex
+	rng *mathrand.Rand
+}
+
+func newSeededUIDGenerator(seed int64) *seededUIDGenerator {
+	return &seededUIDGenerator{rng: mathrand.New(mathrand.NewSource(seed))}
+}
+
+func (g *seededUIDGenerator) NewUID() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, g.rng.Uint64())
+	return hex.EncodeToString(buf), nil
+}
+
+// crockfordAlphabet is the lowercase version of the base32 alphabet used by
+// ULIDs. Lowercasing preserves the sort order.
+const crockfordAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+// ulidGenerator generates monotonic ULIDs (https://github.com/ulid/spec). UIDs
+// generated within the same millisecond increment the random part of the
+// previous one, so they still sort in creation order.
+type ulidGenerator struct {
+	now     func() time.Time
+	entropy io.Reader
+
+	mu sync.Mutex
+	// hi holds the 48 bit timestamp followed by the first 16 bits of the
+	// random part of the last ULID, lo holds the remaining 64 bits.
+	hi, lo uint64
+}
+
+func (g *ulidGenerator) NewUID() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(g.now().UnixMilli())
+	if ms == g.hi>>16 {
+		g.lo++
+		if g.lo == 0 {
+			if g.hi&0xffff == 0xffff {
+				return "", errors.New("ulid random part overflow")
+			}
+			g.hi++
+		}
+	} else {
+		buf := make([]byte, 10)
+		if _, err := io.ReadFull(g.entropy, buf); err != nil {
+			return "", err
+		}
+		g.hi = ms<<16 | uint64(binary.BigEndian.Uint16(buf[:2]))
+		g.lo = binary.BigEndian.Uint64(buf[2:])
+	}
+
+	// encode the 128 bits as 26 characters, starting with the last one
+	out := make([]byte, 26)
+	hi, lo := g.hi, g.lo
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out), nil
+}
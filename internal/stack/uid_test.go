@@ -0,0 +1,80 @@
+package stack
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUIDGenerator(t *testing.T) {
+	newUIDs := func(t *testing.T, g UIDGenerator, n int) []string {
+		t.Helper()
+		var uids []string
+		for i := 0; i < n; i++ {
+			uid, err := g.NewUID()
+			require.NoError(t, err)
+			uids = append(uids, uid)
+		}
+		return uids
+	}
+
+	t.Run("random", func(t *testing.T) {
+		g, err := NewUIDGenerator("random")
+		require.NoError(t, err)
+		uids := newUIDs(t, g, 2)
+		require.Regexp(t, regexp.MustCompile(`^[0-9a-f]{16}$`), uids[0])
+		require.NotEqual(t, uids[0], uids[1])
+	})
+
+	t.Run("seeded", func(t *testing.T) {
+		g1, err := NewUIDGenerator("seeded:42")
+		require.NoError(t, err)
+		g2, err := NewUIDGenerator("seeded:42")
+		require.NoError(t, err)
+		g3, err := NewUIDGenerator("seeded")
+		require.NoError(t, err)
+
+		uids := newUIDs(t, g1, 3)
+		require.Equal(t, uids, newUIDs(t, g2, 3))
+		require.NotEqual(t, uids, newUIDs(t, g3, 3))
+		require.Regexp(t, regexp.MustCompile(`^[0-9a-f]{16}$`), uids[0])
+	})
+
+	t.Run("ulid", func(t *testing.T) {
+		now := time.UnixMilli(1469918176385)
+		g := &ulidGenerator{
+			now:     func() time.Time { return now },
+			entropy: bytes.NewReader(make([]byte, 20)),
+		}
+		uids := newUIDs(t, g, 2)
+		require.Equal(t, "01aryz6s410000000000000000", uids[0])
+		require.Equal(t, "01aryz6s410000000000000001", uids[1])
+
+		now = now.Add(time.Millisecond)
+		uids = append(uids, newUIDs(t, g, 1)...)
+		require.Equal(t, "01aryz6s420000000000000000", uids[2])
+		require.True(t, sort.StringsAreSorted(uids))
+
+		g.hi, g.lo = g.hi|0xffff, ^uint64(0)
+		_, err := g.NewUID()
+		require.EqualError(t, err, "ulid random part overflow")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewUIDGenerator("sequential")
+		require.EqualError(t, err, `unknown uid generator: "sequential"`)
+		_, err = NewUIDGenerator("seeded:x")
+		require.EqualError(t, err, `bad uid generator seed: "x"`)
+	})
+}
+
+func TestFormatUID(t *testing.T) {
+	require.Equal(t, "4d65822107fcfd52", formatUID("Commit-UID", "4d65822107fcfd52"))
+	require.Regexp(t, regexp.MustCompile(`^I[0-9a-f]{40}$`), formatUID("Change-Id", "4d65822107fcfd52"))
+	require.Equal(t, formatUID("Change-Id", "4d65822107fcfd52"), formatUID("change-id", "4d65822107fcfd52"))
+	require.NotEqual(t, formatUID("Change-Id", "4d65822107fcfd52"), formatUID("Change-Id", "78629a0f5f3f164f"))
+}
@@ -0,0 +1,120 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Undo restores the state recorded by the last operation of the journal and
+// removes it from the journal. Changes are only reverted where that is still
+// safe, i.e. if nothing else changed the same ref or pull request since. The
+// skipped changes are returned as notes for the user.
+//
+// Pull requests are restored first, so no pull request is based on a branch
+// while that branch is deleted. Remote branches are compared with their values
+// on the remote, as an interrupted operation may have pushed some of them, and
+// those that still have the value pushed by the operation are restored with a
+// single atomic push that leases that value.
+func Undo(ctx context.Context, c *Context) (*Operation, []string, error) {
+	c = c.withContext(ctx)
+	lock, err := lockRepo(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer lock.Unlock()
+	op, err := LastOperation(c)
+	if err != nil {
+		return nil, nil, err
+	} else if op == nil {
+		return nil, nil, errors.New("nothing to undo")
+	}
+	var notes []string
+
+	if len(op.PullRequests) > 0 && c.forge == nil {
+		return nil, nil, fmt.Errorf("undoing %s requires a forge, enable LoadForge", op.Name)
+	}
+	for i := len(op.PullRequests) - 1; i >= 0; i-- {
+		u := op.PullRequests[i]
+		if u.Old == nil {
+			notes = append(notes, fmt.Sprintf("%s was created by %s and is left open, unless deleting its branch closes it", u.New.URL, op.Name))
+			continue
+		}
+		cur, err := c.forge.FindPullRequest(ctx, u.New.Head)
+		if err != nil {
+			return nil, nil, err
+		} else if cur == nil || cur.Number != u.New.Number {
+			notes = append(notes, fmt.Sprintf("%s is no longer open, not restored", u.New.URL))
+			continue
+		} else if cur.Title != u.New.Title || cur.Body != u.New.Body || cur.Base != u.New.Base {
+			notes = append(notes, fmt.Sprintf("%s was changed since %s, not restored", u.New.URL, op.Name))
+			continue
+		}
+		if err := c.forge.UpdatePullRequest(ctx, u.Old); err != nil {
+			return nil, nil, err
+		}
+		c.log.Debug("restored pull request", "url", u.Old.URL, "base", u.Old.Base)
+	}
+
+	if len(op.Branches) > 0 {
+		restored, err := restoreBranches(c, op)
+		if err != nil {
+			return nil, nil, err
+		}
+		notes = append(notes, restored...)
+	}
+
+	if op.OldLocalHash != op.NewLocalHash {
+		cur, err := c.cmd.Run("git", "rev-parse", "--verify", op.LocalRef+"^{commit}")
+		if err != nil {
+			return nil, nil, err
+		} else if strings.TrimSpace(cur) != op.NewLocalHash {
+			notes = append(notes, fmt.Sprintf("%s was changed since %s, not restored", op.LocalRef, op.Name))
+		} else if _, err := c.cmd.Run("git", "update-ref", "-m", "gh-stack: undo "+op.Name, op.LocalRef, op.OldLocalHash, op.NewLocalHash); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return op, notes, os.Remove(op.file)
+}
+
+// restoreBranches pushes the branches of the operation back to their old
+// values, and returns notes for the ones that were changed by someone else
+// since.
+func restoreBranches(c *Context, op *Operation) ([]string, error) {
+	remote := op.PushRemoteName
+	current, err := lsRemoteBranches(c, remote)
+	if err != nil {
+		return nil, err
+	}
+	var notes []string
+	push := []string{"git", "push", "--atomic", "--progress", remote}
+	var refspecs []string
+	for _, b := range op.Branches {
+		if b.Old == b.New {
+			continue
+		}
+		switch current[b.Branch] {
+		case b.Old:
+			// the operation was interrupted before pushing it
+			continue
+		case b.New:
+		default:
+			notes = append(notes, fmt.Sprintf("%s on %s was changed since %s, not restored", b.Branch, remote, op.Name))
+			continue
+		}
+		ref := "refs/heads/" + b.Branch
+		push = append(push, "--force-with-lease="+ref+":"+b.New)
+		refspecs = append(refspecs, b.Old+":"+ref)
+	}
+	if len(refspecs) == 0 {
+		return notes, nil
+	} else if _, err := c.cmd.RunLogged(append(push, refspecs...)...); IsNonFastForward(err) {
+		notes = append(notes, fmt.Sprintf("branches on %s were changed since %s, not restored", remote, op.Name))
+	} else if err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
@@ -0,0 +1,92 @@
+package stack
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndo(t *testing.T) {
+	env, cleanup := tmpCmdEnv(t)
+	t.Cleanup(func() { require.NoError(t, cleanup()) })
+
+	upstream := env
+	upstream.Dir = filepath.Join(env.Dir, "upstream")
+	require.NoError(t, env.RunMulti([]string{"mkdir", "-p", upstream.Dir}))
+	cmds := [][]string{{"git", "init"}}
+	cmds = append(cmds, createCommitCommands("A", "")...)
+	require.NoError(t, upstream.RunMulti(cmds...))
+	_, err := env.Run("git", "clone", "./upstream", "local")
+	require.NoError(t, err)
+	local := env
+	local.Dir = filepath.Join(env.Dir, "local")
+	cmds = createCommitCommands("B", "uid-b")
+	cmds = append(cmds, createCommitCommands("C", "uid-c")...)
+	require.NoError(t, local.RunMulti(cmds...))
+
+	forge := &fakeForge{}
+	newContext := func(t *testing.T) *Context {
+		t.Helper()
+		c, err := ContextOptions{Dir: local.Dir}.NewContext(context.Background())
+		require.NoError(t, err)
+		c.config.RemoteOwner, c.config.RemoteRepo = "acme", "widgets"
+		c.config.PushOwner, c.config.PushRepo = "acme", "widgets"
+		c.forge = forge
+		c.uids = newSeededUIDGenerator(1)
+		return c
+	}
+	rev := func(t *testing.T, env CmdEnv, ref string) string {
+		t.Helper()
+		out, err := env.Run("git", "rev-parse", "--verify", "--quiet", ref)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(out)
+	}
+
+	_, err = Sync(context.Background(), newContext(t))
+	require.NoError(t, err)
+	oldC := rev(t, upstream, "gh-stack-commit-uid-c")
+	require.NotEmpty(t, oldC)
+
+	// nothing changes, so there is nothing new to undo
+	_, err = Sync(context.Background(), newContext(t))
+	require.NoError(t, err)
+
+	// reword C and add D without UID
+	cmds = [][]string{{"git", "commit", "--amend", "-m", "C\n\nReworded\n\nCommit-UID: uid-c"}}
+	cmds = append(cmds, createCommitCommands("D", "")...)
+	require.NoError(t, local.RunMulti(cmds...))
+	oldHead := rev(t, local, "HEAD")
+	prs, err := Sync(context.Background(), newContext(t))
+	require.NoError(t, err)
+	require.Len(t, prs, 3)
+	require.NotEqual(t, oldHead, rev(t, local, "HEAD"))
+	require.Equal(t, "Reworded\n\nCommit-UID: uid-c", forge.prs[1].Body)
+	require.Len(t, forge.prs, 3)
+
+	op, notes, err := Undo(context.Background(), newContext(t))
+	require.NoError(t, err)
+	require.Equal(t, "sync", op.Name)
+	require.Equal(t, []string{prs[0].URL + " was created by sync and is left open, unless deleting its branch closes it"}, notes)
+	require.Equal(t, oldHead, rev(t, local, "HEAD"))
+	branch, err := local.Run("git", "rev-parse", "--abbrev-ref", "HEAD")
+	require.NoError(t, err)
+	require.Equal(t, "main", strings.TrimSpace(branch))
+	require.Equal(t, oldC, rev(t, upstream, "gh-stack-commit-uid-c"))
+	require.Empty(t, rev(t, upstream, prs[0].Head))
+	require.Equal(t, "This is commit: C\nCommit-UID: uid-c", forge.prs[1].Body)
+
+	// the first sync is next, it only created pull requests
+	_, notes, err = Undo(context.Background(), newContext(t))
+	require.NoError(t, err)
+	require.Len(t, notes, 2)
+	require.Empty(t, rev(t, upstream, "gh-stack-commit-uid-b"))
+	require.Empty(t, rev(t, upstream, "gh-stack-commit-uid-c"))
+
+	_, _, err = Undo(context.Background(), newContext(t))
+	require.EqualError(t, err, "nothing to undo")
+}